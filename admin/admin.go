@@ -0,0 +1,471 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/JSainsburyPLC/ui-dev-proxy/proxy"
+)
+
+// Admin serves an HTTP API for inspecting and changing the live config of a proxy, and a dashboard of the
+// proxy's traffic
+type Admin struct {
+	server *http.Server
+}
+
+// NewAdmin creates an admin server listening on host and port. The admin API can change where the proxy sends
+// requests and shows their headers, so host should be a loopback address unless every machine that can reach it
+// is trusted
+func NewAdmin(host string, port int, p *proxy.Proxy, logger *log.Logger) *Admin {
+	return &Admin{
+		server: &http.Server{
+			Addr:    net.JoinHostPort(host, strconv.Itoa(port)),
+			Handler: handler(p, logger, host),
+		},
+	}
+}
+
+func (a *Admin) Start() {
+	err := a.server.ListenAndServe()
+	if err != nil {
+		panic(err)
+	}
+}
+
+type mocksState struct {
+	Enabled bool `json:"enabled"`
+}
+
+type scenarioState struct {
+	State string `json:"state"`
+}
+
+// handler serves the admin API to requests that checkRequest allows, where host is the address it listens on
+func handler(p *proxy.Proxy, logger *log.Logger, host string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/routes", routesHandler(p))
+	mux.HandleFunc("/routes/", routeHandler(p))
+	mux.HandleFunc("/mocks", mocksHandler(p, logger))
+	mux.HandleFunc("/chaos", chaosHandler(p))
+	mux.HandleFunc("/requests", requestsHandler(p))
+	mux.HandleFunc("/scenarios", scenariosHandler(p))
+	mux.HandleFunc("/scenarios/", scenarioHandler(p))
+	mux.HandleFunc("/traffic", trafficHandler(p))
+	mux.HandleFunc("/traffic/", exchangeHandler(p))
+	mux.HandleFunc("/", dashboardHandler())
+	return checkRequest(host, mux)
+}
+
+// checkRequest rejects requests that a web page could have sent, so the pages a developer visits can't change the
+// routes or read the traffic. That's bodies that aren't JSON, which a cross-origin form or fetch can send without a
+// preflight, requests from another origin, and requests for a host name other than the admin address, which is how
+// DNS rebinding makes a page's requests same-origin
+func checkRequest(adminHost string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host, adminHost) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host '%s' isn't allowed", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !strings.EqualFold(u.Host, r.Host) {
+				writeError(w, http.StatusForbidden, fmt.Errorf("origin '%s' isn't allowed", origin))
+				return
+			}
+		}
+		if r.ContentLength != 0 {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errors.New("request body must be application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost checks the Host header is localhost, a loopback address or the admin address. When the admin API
+// listens on every interface, any IP address is allowed too, as DNS rebinding needs a host name
+func allowedHost(hostPort string, adminHost string) bool {
+	host := hostPort
+	if h, _, err := net.SplitHostPort(hostPort); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	if strings.EqualFold(host, "localhost") || strings.EqualFold(host, adminHost) {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	adminIP := net.ParseIP(adminHost)
+	return adminHost == "" || (adminIP != nil && adminIP.IsUnspecified())
+}
+
+// routesHandler lists the routes in match order, appends or inserts a route, or replaces all of them
+func routesHandler(p *proxy.Proxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var route domain.Route
+			if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			err := p.UpdateConfig(func(conf domain.Config) (domain.Config, error) {
+				index := len(conf.Routes)
+				if v := r.URL.Query().Get("index"); v != "" {
+					i, err := routeIndex(v, len(conf.Routes)+1)
+					if err != nil {
+						return conf, err
+					}
+					index = i
+				}
+
+				conf.Routes = append(conf.Routes, domain.Route{})
+				copy(conf.Routes[index+1:], conf.Routes[index:])
+				conf.Routes[index] = unchangedRoute(conf.Routes, route)
+				return conf, nil
+			})
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		case http.MethodPut:
+			var newConf domain.Config
+			if err := json.NewDecoder(r.Body).Decode(&newConf); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			err := p.UpdateConfig(func(conf domain.Config) (domain.Config, error) {
+				routes := make([]domain.Route, len(newConf.Routes))
+				for i, route := range newConf.Routes {
+					routes[i] = unchangedRoute(conf.Routes, route)
+				}
+				conf.Routes = routes
+				return conf, nil
+			})
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, domain.Config{Routes: p.Config().Routes})
+	}
+}
+
+// routeHandler gets, replaces, removes or moves the route at /routes/{index}, or changes its chaos settings
+// at /routes/{index}/chaos
+func routeHandler(p *proxy.Proxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/routes/"), "/")
+		if len(parts) > 2 || (len(parts) == 2 && parts[1] != "move" && parts[1] != "chaos") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if len(parts) == 2 && parts[1] == "chaos" {
+			routeChaosHandler(p, parts[0])(w, r)
+			return
+		}
+
+		if len(parts) == 2 {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			err := p.UpdateConfig(func(conf domain.Config) (domain.Config, error) {
+				from, err := routeIndex(parts[0], len(conf.Routes))
+				if err != nil {
+					return conf, err
+				}
+				to, err := routeIndex(r.URL.Query().Get("to"), len(conf.Routes))
+				if err != nil {
+					return conf, err
+				}
+
+				route := conf.Routes[from]
+				conf.Routes = append(conf.Routes[:from], conf.Routes[from+1:]...)
+				conf.Routes = append(conf.Routes[:to], append([]domain.Route{route}, conf.Routes[to:]...)...)
+				return conf, nil
+			})
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			writeJSON(w, http.StatusOK, domain.Config{Routes: p.Config().Routes})
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			routes := p.Config().Routes
+			i, err := routeIndex(parts[0], len(routes))
+			if err != nil {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, routes[i])
+			return
+		case http.MethodPut:
+			var route domain.Route
+			if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			err := p.UpdateConfig(func(conf domain.Config) (domain.Config, error) {
+				i, err := routeIndex(parts[0], len(conf.Routes))
+				if err != nil {
+					return conf, err
+				}
+				conf.Routes[i] = unchangedRoute(conf.Routes, route)
+				return conf, nil
+			})
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		case http.MethodDelete:
+			err := p.UpdateConfig(func(conf domain.Config) (domain.Config, error) {
+				i, err := routeIndex(parts[0], len(conf.Routes))
+				if err != nil {
+					return conf, err
+				}
+				conf.Routes = append(conf.Routes[:i], conf.Routes[i+1:]...)
+				return conf, nil
+			})
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, domain.Config{Routes: p.Config().Routes})
+	}
+}
+
+// routeChaosHandler gets, sets or removes the chaos settings of the route at index
+func routeChaosHandler(p *proxy.Proxy, index string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var chaos *domain.Chaos
+		switch r.Method {
+		case http.MethodGet:
+			routes := p.Config().Routes
+			i, err := routeIndex(index, len(routes))
+			if err != nil {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, routes[i].Chaos)
+			return
+		case http.MethodPut:
+			if err := json.NewDecoder(r.Body).Decode(&chaos); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		case http.MethodDelete:
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := p.UpdateConfig(func(conf domain.Config) (domain.Config, error) {
+			i, err := routeIndex(index, len(conf.Routes))
+			if err != nil {
+				return conf, err
+			}
+			conf.Routes[i].Chaos = chaos
+			return conf, nil
+		})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, chaos)
+	}
+}
+
+// chaosHandler gets, sets or removes the chaos settings for the default backend and routes without their own
+func chaosHandler(p *proxy.Proxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var chaos *domain.Chaos
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, p.Config().Chaos)
+			return
+		case http.MethodPut:
+			if err := json.NewDecoder(r.Body).Decode(&chaos); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		case http.MethodDelete:
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := p.UpdateConfig(func(conf domain.Config) (domain.Config, error) {
+			conf.Chaos = chaos
+			return conf, nil
+		})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, chaos)
+	}
+}
+
+// mocksHandler reports or toggles whether mock routes are matched
+func mocksHandler(p *proxy.Proxy, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var state mocksState
+			if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			p.SetMocksEnabled(state.Enabled)
+			logger.Printf("Mocks enabled: %t\n", state.Enabled)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, mocksState{Enabled: p.MocksEnabled()})
+	}
+}
+
+// requestsHandler lists which route the most recent requests matched, newest first. The number
+// returned can be limited with the limit query param
+func requestsHandler(p *proxy.Proxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit := 0
+		if v := r.URL.Query().Get("limit"); v != "" {
+			l, err := strconv.Atoi(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit '%s'", v))
+				return
+			}
+			limit = l
+		}
+
+		writeJSON(w, http.StatusOK, p.Matches(limit))
+	}
+}
+
+// scenariosHandler lists the state of every scenario that has left its started state, or resets them all
+func scenariosHandler(p *proxy.Proxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodDelete:
+			p.Scenarios().ResetAll()
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, p.Scenarios().States())
+	}
+}
+
+// scenarioHandler gets, sets or resets the state of the scenario at /scenarios/{name}
+func scenarioHandler(p *proxy.Proxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/scenarios/")
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var state scenarioState
+			if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			p.Scenarios().SetState(name, state.State)
+		case http.MethodDelete:
+			p.Scenarios().Reset(name)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, scenarioState{State: p.Scenarios().State(name)})
+	}
+}
+
+func routeIndex(s string, n int) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 || i >= n {
+		return 0, fmt.Errorf("invalid route index '%s'", s)
+	}
+	return i, nil
+}
+
+// unchangedRoute returns the route in routes that serialises the same as route, if there is one. A route sent
+// back unchanged then keeps what isn't serialised, such as the content type and encoded variants of a body read
+// from a file, a binary body, and where the route was declared
+func unchangedRoute(routes []domain.Route, route domain.Route) domain.Route {
+	sent, err := json.Marshal(route)
+	if err != nil {
+		return route
+	}
+	for _, existing := range routes {
+		if b, err := json.Marshal(existing); err == nil && bytes.Equal(b, sent) {
+			return existing
+		}
+	}
+	return route
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,347 @@
+package admin
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/JSainsburyPLC/ui-dev-proxy/proxy"
+	"github.com/steinfletcher/apitest"
+)
+
+func newProxy() *proxy.Proxy {
+	u, _ := url.Parse("http://test-backend")
+	logger := log.New(ioutil.Discard, "", log.LstdFlags)
+	return proxy.NewProxy(8080, config(), u, false, logger)
+}
+
+func newApiTest(p *proxy.Proxy) *apitest.APITest {
+	logger := log.New(ioutil.Discard, "", log.LstdFlags)
+	return apitest.New().
+		Intercept(func(r *http.Request) { r.Host = "localhost:8081" }).
+		Handler(handler(p, logger, "127.0.0.1"))
+}
+
+func TestAdmin_ListRoutes(t *testing.T) {
+	newApiTest(newProxy()).
+		Get("/routes").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"routes": [
+			{"type": "proxy", "path_pattern": "^/test-ui/.*", "backend": "http://localhost:3001"},
+			{"type": "redirect", "path_pattern": "^/old-ui/(.*)", "redirect": {"to": "/new-ui/$1", "type": "temporary"}}
+		]}`).
+		End()
+}
+
+func TestAdmin_AddRoute(t *testing.T) {
+	p := newProxy()
+
+	newApiTest(p).
+		Post("/routes").
+		Query("index", "0").
+		JSON(`{"type": "mock", "mock": {"request": {"path": "^/api/.*"}, "response": {"status": 204}}}`).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"routes": [
+			{"type": "mock", "mock": {"request": {"path": "^/api/.*"}, "response": {"status": 204, "body": ""}}},
+			{"type": "proxy", "path_pattern": "^/test-ui/.*", "backend": "http://localhost:3001"},
+			{"type": "redirect", "path_pattern": "^/old-ui/(.*)", "redirect": {"to": "/new-ui/$1", "type": "temporary"}}
+		]}`).
+		End()
+}
+
+func TestAdmin_AddRoute_Invalid(t *testing.T) {
+	p := newProxy()
+
+	newApiTest(p).
+		Post("/routes").
+		JSON(`{"type": "not_a_route", "path_pattern": "^/api/.*"}`).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		Body(`{"error": "routes[2].type: unknown route type 'not_a_route'"}`).
+		End()
+
+	if len(p.Config().Routes) != 2 {
+		t.Fatal("invalid route should not have been added")
+	}
+}
+
+func TestAdmin_RejectsWebPageRequests(t *testing.T) {
+	tests := map[string]struct {
+		host        string
+		origin      string
+		contentType string
+		status      int
+		body        string
+	}{
+		"form body": {
+			host: "localhost:8081", contentType: "text/plain",
+			status: http.StatusUnsupportedMediaType, body: `{"error": "request body must be application/json"}`,
+		},
+		"cross-origin": {
+			host: "localhost:8081", origin: "https://evil.example.com", contentType: "application/json",
+			status: http.StatusForbidden, body: `{"error": "origin 'https://evil.example.com' isn't allowed"}`,
+		},
+		"rebound host name": {
+			host: "evil.example.com:8081", origin: "http://evil.example.com:8081", contentType: "application/json",
+			status: http.StatusForbidden, body: `{"error": "host 'evil.example.com:8081' isn't allowed"}`,
+		},
+		"same origin": {
+			host: "127.0.0.1:8081", origin: "http://127.0.0.1:8081", contentType: "application/json; charset=utf-8",
+			status: http.StatusOK,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := newProxy()
+			a := newApiTest(p).
+				Intercept(func(r *http.Request) { r.Host = test.host }).
+				Post("/routes").
+				Query("index", "0").
+				Body(`{"type": "proxy", "path_pattern": "^/", "backend": "https://evil.example.com"}`).
+				ContentType(test.contentType)
+			if test.origin != "" {
+				a = a.Header("Origin", test.origin)
+			}
+			res := a.Expect(t).Status(test.status)
+			if test.body != "" {
+				res = res.Body(test.body)
+			}
+			res.End()
+
+			expectedRoutes := 2
+			if test.status == http.StatusOK {
+				expectedRoutes = 3
+			}
+			if len(p.Config().Routes) != expectedRoutes {
+				t.Fatalf("expected %d routes, got %d", expectedRoutes, len(p.Config().Routes))
+			}
+		})
+	}
+
+	newApiTest(newProxy()).
+		Intercept(func(r *http.Request) { r.Host = "evil.example.com" }).
+		Get("/traffic").
+		Expect(t).
+		Status(http.StatusForbidden).
+		End()
+}
+
+func TestAdmin_MoveRoute(t *testing.T) {
+	newApiTest(newProxy()).
+		Post("/routes/1/move").
+		Query("to", "0").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"routes": [
+			{"type": "redirect", "path_pattern": "^/old-ui/(.*)", "redirect": {"to": "/new-ui/$1", "type": "temporary"}},
+			{"type": "proxy", "path_pattern": "^/test-ui/.*", "backend": "http://localhost:3001"}
+		]}`).
+		End()
+}
+
+func TestAdmin_DeleteRoute(t *testing.T) {
+	newApiTest(newProxy()).
+		Delete("/routes/0").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"routes": [
+			{"type": "redirect", "path_pattern": "^/old-ui/(.*)", "redirect": {"to": "/new-ui/$1", "type": "temporary"}}
+		]}`).
+		End()
+}
+
+func TestAdmin_ReplaceRoutes_KeepsUnchangedRoutes(t *testing.T) {
+	conf := config()
+	conf.Routes = append(conf.Routes, domain.Route{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Path: "^/logo.png$"},
+			Response: domain.Response{
+				Status:        200,
+				Body:          "\x89PNG\xff",
+				ContentType:   "image/png",
+				EncodedBodies: map[string]string{"gzip": "\x1f\x8b"},
+			},
+		},
+		Origin: &domain.Origin{File: "mocks.json", Path: "routes[0]"},
+	})
+	u, _ := url.Parse("http://test-backend")
+	p := proxy.NewProxy(8080, conf, u, false, log.New(ioutil.Discard, "", log.LstdFlags))
+	h := handler(p, log.New(ioutil.Discard, "", log.LstdFlags), "127.0.0.1")
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:8081"+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	res := serve(http.MethodGet, "/routes", "")
+	routes := res.Body.String()
+
+	res = serve(http.MethodPut, "/routes", routes)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", res.Code, res.Body.String())
+	}
+
+	res = serve(http.MethodGet, "/routes/2", "")
+	route := res.Body.String()
+
+	res = serve(http.MethodPut, "/routes/2", route)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", res.Code, res.Body.String())
+	}
+
+	mock := p.Config().Routes[2]
+	if !reflect.DeepEqual(mock, conf.Routes[2]) {
+		t.Fatalf("expected the unchanged route to be kept, got %+v", mock)
+	}
+}
+
+func TestAdmin_ToggleMocks(t *testing.T) {
+	p := newProxy()
+
+	newApiTest(p).
+		Put("/mocks").
+		JSON(`{"enabled": true}`).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"enabled": true}`).
+		End()
+
+	if !p.MocksEnabled() {
+		t.Fatal("expected mocks to be enabled")
+	}
+}
+
+func TestAdmin_Scenarios(t *testing.T) {
+	p := newProxy()
+
+	newApiTest(p).
+		Put("/scenarios/basket").
+		JSON(`{"state": "has-items"}`).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"state": "has-items"}`).
+		End()
+
+	newApiTest(p).
+		Get("/scenarios").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"basket": "has-items"}`).
+		End()
+
+	newApiTest(p).
+		Delete("/scenarios").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{}`).
+		End()
+
+	newApiTest(p).
+		Get("/scenarios/basket").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"state": "started"}`).
+		End()
+}
+
+func TestAdmin_Chaos(t *testing.T) {
+	p := newProxy()
+
+	newApiTest(p).
+		Put("/chaos").
+		JSON(`{"latency": "200ms", "error_rate": 0.5}`).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"latency": "200ms", "error_rate": 0.5}`).
+		End()
+
+	newApiTest(p).
+		Put("/routes/0/chaos").
+		JSON(`{"error_rate": 2}`).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		Body(`{"error": "routes[0].chaos: chaos error_rate must be between 0 and 1"}`).
+		End()
+
+	newApiTest(p).
+		Put("/routes/0/chaos").
+		JSON(`{"bytes_per_second": 1024}`).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"bytes_per_second": 1024}`).
+		End()
+
+	if p.Config().Routes[0].Chaos.BytesPerSecond != 1024 {
+		t.Fatal("expected route chaos to be set")
+	}
+}
+
+func config() domain.Config {
+	backend, _ := url.Parse("http://localhost:3001")
+	return domain.Config{
+		Routes: []domain.Route{
+			{
+				Type:        domain.RouteTypeProxy,
+				PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/test-ui/.*")},
+				Backend:     &domain.Backend{URL: backend},
+			},
+			{
+				Type:        domain.RouteTypeRedirect,
+				PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/old-ui/(.*)")},
+				Redirect:    &domain.Redirect{To: "/new-ui/$1", Type: "temporary"},
+			},
+		},
+	}
+}
+
+func TestAdmin_Traffic(t *testing.T) {
+	p := newProxy()
+	p.CaptureTraffic()
+
+	newApiTest(p).
+		Get("/traffic").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`[]`).
+		End()
+
+	newApiTest(p).
+		Get("/traffic/1").
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+
+	newApiTest(p).
+		Get("/traffic").
+		Query("since", "latest").
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+}
+
+func TestAdmin_Dashboard(t *testing.T) {
+	newApiTest(newProxy()).
+		Get("/").
+		Expect(t).
+		Status(http.StatusOK).
+		Header("Content-Type", "text/html; charset=utf-8").
+		End()
+
+	newApiTest(newProxy()).
+		Get("/unknown").
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+}
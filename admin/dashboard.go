@@ -0,0 +1,234 @@
+package admin
+
+// dashboardHTML is a single page that polls /traffic and shows each exchange in detail
+const dashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>UI Dev Proxy traffic</title>
+<style>
+  body { font-family: system-ui, sans-serif; margin: 0; color: #1a1a1a; background: #fff; }
+  header { display: flex; gap: 1rem; align-items: center; padding: 0.5rem 1rem; border-bottom: 1px solid #ccc; }
+  h1 { font-size: 1.1rem; margin: 0; }
+  main { display: grid; grid-template-columns: minmax(0, 3fr) minmax(0, 2fr); height: calc(100vh - 3rem); }
+  #list { overflow: auto; border-right: 1px solid #ccc; }
+  #detail { overflow: auto; padding: 0 1rem 1rem; }
+  table { border-collapse: collapse; width: 100%; font-size: 0.85rem; }
+  caption { text-align: left; padding: 0.5rem; font-weight: bold; }
+  th, td { text-align: left; padding: 0.25rem 0.5rem; border-bottom: 1px solid #eee; white-space: nowrap; }
+  td.url { white-space: normal; word-break: break-all; }
+  tr.selected { background: #e8f0fe; }
+  tr.error td { color: #a50e0e; }
+  button { font: inherit; cursor: pointer; }
+  td button { background: none; border: none; padding: 0; color: #0b57d0; text-decoration: underline; }
+  :focus-visible { outline: 3px solid #0b57d0; outline-offset: 2px; }
+  pre { background: #f6f8fa; padding: 0.5rem; overflow: auto; max-height: 20rem; white-space: pre-wrap; word-break: break-all; }
+  dl { display: grid; grid-template-columns: max-content auto; gap: 0.25rem 1rem; font-size: 0.85rem; }
+  dt { font-weight: bold; }
+  dd { margin: 0; word-break: break-all; }
+  .matched { color: #0d652d; }
+  .unmatched { color: #a50e0e; }
+  textarea { width: 100%; min-height: 12rem; font-family: monospace; }
+</style>
+</head>
+<body>
+<header>
+  <h1>UI Dev Proxy traffic</h1>
+  <button id="pause" type="button" aria-pressed="false">Pause</button>
+  <button id="clear" type="button">Clear</button>
+  <p id="status" role="status" aria-live="polite"></p>
+</header>
+<main>
+  <section id="list" aria-label="Requests">
+    <table>
+      <caption>Most recent requests, newest first</caption>
+      <thead>
+        <tr>
+          <th scope="col">Time</th>
+          <th scope="col">Method</th>
+          <th scope="col">URL</th>
+          <th scope="col">Status</th>
+          <th scope="col">Handled by</th>
+          <th scope="col">Time taken</th>
+        </tr>
+      </thead>
+      <tbody id="rows"></tbody>
+    </table>
+  </section>
+  <section id="detail" aria-label="Request details" tabindex="-1">
+    <p>Select a request to see its details.</p>
+  </section>
+</main>
+<script>
+(function () {
+  var since = 0;
+  var paused = false;
+  var selected = null;
+  var rows = document.getElementById('rows');
+  var detail = document.getElementById('detail');
+  var status = document.getElementById('status');
+
+  function el(tag, text, className) {
+    var e = document.createElement(tag);
+    if (text !== undefined) e.textContent = text;
+    if (className) e.className = className;
+    return e;
+  }
+
+  function handledBy(x) {
+    if (x.route_index < 0) return 'default backend';
+    var name = x.route_name ? ' "' + x.route_name + '"' : '';
+    return x.action + ' (route ' + x.route_index + name + ')';
+  }
+
+  function addRow(x) {
+    var tr = document.createElement('tr');
+    tr.id = 'exchange-' + x.id;
+    if (x.status >= 500 || x.error) tr.className = 'error';
+    tr.appendChild(el('td', new Date(x.time).toLocaleTimeString()));
+    tr.appendChild(el('td', x.method));
+    var url = el('td', undefined, 'url');
+    var button = el('button', x.url);
+    button.type = 'button';
+    button.addEventListener('click', function () { show(x.id); });
+    url.appendChild(button);
+    tr.appendChild(url);
+    tr.appendChild(el('td', x.status ? String(x.status) : 'none'));
+    tr.appendChild(el('td', handledBy(x)));
+    tr.appendChild(el('td', x.latency_ms.toFixed(1) + ' ms'));
+    rows.insertBefore(tr, rows.firstChild);
+  }
+
+  function poll() {
+    if (paused) return;
+    fetch('/traffic?since=' + since)
+      .then(function (res) { return res.json(); })
+      .then(function (exchanges) {
+        exchanges.forEach(function (x) {
+          addRow(x);
+          since = x.id;
+        });
+        if (exchanges.length) status.textContent = exchanges.length + ' new requests';
+      })
+      .catch(function () { status.textContent = 'Lost connection to the proxy'; });
+  }
+
+  function headers(title, h) {
+    var frag = document.createDocumentFragment();
+    frag.appendChild(el('h3', title));
+    var dl = el('dl');
+    Object.keys(h || {}).sort().forEach(function (name) {
+      dl.appendChild(el('dt', name));
+      dl.appendChild(el('dd', h[name].join(', ')));
+    });
+    frag.appendChild(dl);
+    return frag;
+  }
+
+  function body(title, b) {
+    var frag = document.createDocumentFragment();
+    frag.appendChild(el('h3', title));
+    if (!b) {
+      frag.appendChild(el('p', 'No body'));
+      return frag;
+    }
+    try { b = JSON.stringify(JSON.parse(b), null, 2); } catch (e) {}
+    frag.appendChild(el('pre', b));
+    return frag;
+  }
+
+  function show(id) {
+    fetch('/traffic/' + id)
+      .then(function (res) { return res.json(); })
+      .then(function (x) {
+        if (selected) selected.classList.remove('selected');
+        selected = document.getElementById('exchange-' + id);
+        if (selected) selected.classList.add('selected');
+
+        detail.textContent = '';
+        detail.appendChild(el('h2', x.method + ' ' + x.url));
+
+        var summary = el('dl');
+        [['Status', x.status || 'none'], ['Protocol', x.protocol], ['Server', x.server || 'top level routes'],
+         ['Handled by', handledBy(x)], ['Backend', x.backend || ''], ['Redirected to', x.redirect_to || ''],
+         ['Time taken', x.latency_ms.toFixed(1) + ' ms'], ['Error', x.error || '']].forEach(function (f) {
+          if (f[1] === '') return;
+          summary.appendChild(el('dt', f[0]));
+          summary.appendChild(el('dd', String(f[1])));
+        });
+        detail.appendChild(summary);
+
+        detail.appendChild(el('h3', 'Routes'));
+        var routes = el('ol');
+        routes.start = 0;
+        (x.routes || []).forEach(function (r) {
+          var name = r.name ? ' "' + r.name + '"' : '';
+          if (r.origin) name += ' in ' + r.origin;
+          var li = el('li', r.type + name + ': ' + (r.matched ? 'matched' : 'didn\'t match'), r.matched ? 'matched' : 'unmatched');
+          if (r.reasons) {
+            var reasons = el('ul');
+            r.reasons.forEach(function (reason) { reasons.appendChild(el('li', reason)); });
+            li.appendChild(reasons);
+          }
+          routes.appendChild(li);
+        });
+        if (!(x.routes || []).some(function (r) { return r.matched; })) {
+          detail.appendChild(el('p', 'No route matched, so the request went to the default backend.'));
+        }
+        detail.appendChild(routes);
+
+        (x.notes || []).forEach(function (n) { detail.appendChild(el('p', n)); });
+        detail.appendChild(headers('Request headers', x.request_headers));
+        detail.appendChild(body('Request body', x.request_body));
+        detail.appendChild(headers('Response headers', x.response_headers));
+        detail.appendChild(body('Response body', x.response_body));
+
+        var mockButton = el('button', 'Turn this into a mock');
+        mockButton.type = 'button';
+        mockButton.addEventListener('click', function () { showMock(id); });
+        detail.appendChild(mockButton);
+        detail.focus();
+      });
+  }
+
+  function showMock(id) {
+    fetch('/traffic/' + id + '/mock')
+      .then(function (res) { return res.json(); })
+      .then(function (route) {
+        var existing = document.getElementById('mock');
+        if (existing) existing.parentNode.removeChild(existing);
+        var label = el('label', 'Mock route to add to your config');
+        label.htmlFor = 'mock';
+        var text = el('textarea');
+        text.id = 'mock';
+        text.readOnly = true;
+        text.value = JSON.stringify(route, null, 2);
+        detail.appendChild(label);
+        detail.appendChild(text);
+        text.focus();
+        text.select();
+      });
+  }
+
+  document.getElementById('pause').addEventListener('click', function (e) {
+    paused = !paused;
+    e.target.textContent = paused ? 'Resume' : 'Pause';
+    e.target.setAttribute('aria-pressed', String(paused));
+    status.textContent = paused ? 'Paused' : 'Resumed';
+  });
+
+  document.getElementById('clear').addEventListener('click', function () {
+    fetch('/traffic', { method: 'DELETE' }).then(function () {
+      rows.textContent = '';
+      status.textContent = 'Cleared';
+    });
+  });
+
+  poll();
+  setInterval(poll, 1000);
+})();
+</script>
+</body>
+</html>
+`
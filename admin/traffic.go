@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/proxy"
+)
+
+// trafficHandler lists the captured exchanges after the ?since= ID, or forgets all of them
+func trafficHandler(p *proxy.Proxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			var since int64
+			if v := r.URL.Query().Get("since"); v != "" {
+				s, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, fmt.Errorf("invalid since '%s'", v))
+					return
+				}
+				since = s
+			}
+			writeJSON(w, http.StatusOK, p.Traffic(since))
+		case http.MethodDelete:
+			p.ClearTraffic()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// exchangeHandler gets a captured exchange by ID, or a mock route built from it with ID/mock
+func exchangeHandler(p *proxy.Proxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/traffic/"), "/")
+		id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid exchange id '%s'", parts[0]))
+			return
+		}
+		exchange, ok := p.TrafficExchange(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("exchange %d not found", id))
+			return
+		}
+
+		switch {
+		case len(parts) == 1:
+			writeJSON(w, http.StatusOK, exchange)
+		case len(parts) == 2 && parts[1] == "mock":
+			writeJSON(w, http.StatusOK, exchange.MockRoute())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+// dashboardHandler serves the traffic dashboard
+func dashboardHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(dashboardHTML))
+	}
+}
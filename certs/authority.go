@@ -0,0 +1,321 @@
+package certs
+
+import (
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca-key.pem"
+
+	caValidity = 10 * 365 * 24 * time.Hour
+	// browsers reject leaf certificates valid for longer than 398 days
+	leafValidity = 397 * 24 * time.Hour
+	// maxLeaves bounds the memory used by cached certificates, as clients can ask for any host name
+	maxLeaves = 1000
+)
+
+// Authority is a local certificate authority that issues leaf certificates for any host name on demand,
+// so the proxy can serve HTTPS for whatever host names it's requested on
+type Authority struct {
+	// CertFile is the path of the CA certificate, to be trusted by browsers and other clients
+	CertFile string
+	// Created is true if the CA was generated rather than loaded from disk
+	Created bool
+
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	leafKey *ecdsa.PrivateKey
+
+	mu        sync.Mutex
+	maxLeaves int
+	// leaves holds the cached certificates in order, most recently used first, and byName finds them
+	leaves *list.List
+	byName map[string]*list.Element
+}
+
+type leaf struct {
+	name string
+	cert *tls.Certificate
+}
+
+// LoadOrCreateAuthority loads the CA from dir, generating and saving a new one if there isn't one yet
+func LoadOrCreateAuthority(dir string) (*Authority, error) {
+	certFile := filepath.Join(dir, caCertFile)
+	keyFile := filepath.Join(dir, caKeyFile)
+
+	certExists, err := fileExists(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyExists, err := fileExists(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var cert *x509.Certificate
+	var key *ecdsa.PrivateKey
+	created := false
+	switch {
+	case !certExists && !keyExists:
+		cert, key, err = createCA(dir, certFile, keyFile)
+		created = true
+	case !certExists:
+		// a new CA would replace the one clients already trust, so don't generate one to replace a lost file
+		err = fmt.Errorf("CA key %s exists but its certificate %s is missing: restore it, or remove the key to generate a new CA", keyFile, certFile)
+	case !keyExists:
+		err = fmt.Errorf("CA certificate %s exists but its key %s is missing: restore it, or remove the certificate to generate a new CA", certFile, keyFile)
+	default:
+		cert, key, err = loadCA(certFile, keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authority{
+		CertFile:  certFile,
+		Created:   created,
+		cert:      cert,
+		key:       key,
+		leafKey:   leafKey,
+		maxLeaves: maxLeaves,
+		leaves:    list.New(),
+		byName:    map[string]*list.Element{},
+	}, nil
+}
+
+func fileExists(name string) (bool, error) {
+	_, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func loadCA(certFile string, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("no certificate found in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("no private key found in %s", keyFile)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+func createCA(dir string, certFile string, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"UI Dev Proxy local CA"},
+			CommonName:   "UI Dev Proxy " + hostname,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+// GetCertificate returns a certificate for the host name the client asked for with SNI, issuing it if
+// needed. Clients that don't send SNI, such as those connecting to an IP address, get a certificate for
+// localhost and the loopback addresses. It's used as tls.Config.GetCertificate
+func (a *Authority) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		name = "localhost"
+	}
+
+	if cert, ok := a.cachedLeaf(name); ok {
+		return cert, nil
+	}
+
+	// issued without holding the lock, so a handshake for a new name doesn't hold up the others
+	cert, err := a.issue(name)
+	if err != nil {
+		return nil, err
+	}
+	a.cacheLeaf(name, cert)
+	return cert, nil
+}
+
+// cachedLeaf returns the cached certificate for the name, if it hasn't expired
+func (a *Authority) cachedLeaf(name string) (*tls.Certificate, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, ok := a.byName[name]
+	if !ok {
+		return nil, false
+	}
+	cert := e.Value.(*leaf).cert
+	if !time.Now().Before(cert.Leaf.NotAfter) {
+		return nil, false
+	}
+	a.leaves.MoveToFront(e)
+	return cert, true
+}
+
+// cacheLeaf caches the certificate for the name, evicting the least recently used if there are too many
+func (a *Authority) cacheLeaf(name string, cert *tls.Certificate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if e, ok := a.byName[name]; ok {
+		e.Value.(*leaf).cert = cert
+		a.leaves.MoveToFront(e)
+		return
+	}
+	a.byName[name] = a.leaves.PushFront(&leaf{name: name, cert: cert})
+	for a.leaves.Len() > a.maxLeaves {
+		oldest := a.leaves.Back()
+		a.leaves.Remove(oldest)
+		delete(a.byName, oldest.Value.(*leaf).name)
+	}
+}
+
+func (a *Authority) issue(name string) (*tls.Certificate, error) {
+	if strings.ContainsAny(name, " /\\") {
+		return nil, errors.New("invalid server name")
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"UI Dev Proxy local certificate"},
+			CommonName:   name,
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(leafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+	if name == "localhost" {
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	}
+	if template.NotAfter.After(a.cert.NotAfter) {
+		template.NotAfter = a.cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &a.leafKey.PublicKey, a.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, a.cert.Raw},
+		PrivateKey:  a.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+// TrustInstructions describes how to make common platforms and browsers trust the CA
+func (a *Authority) TrustInstructions() string {
+	return strings.Join([]string{
+		fmt.Sprintf("To trust the local CA %s:", a.CertFile),
+		fmt.Sprintf("  macOS:   sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain %s", a.CertFile),
+		fmt.Sprintf("  Linux:   sudo cp %s /usr/local/share/ca-certificates/ui-dev-proxy.crt && sudo update-ca-certificates", a.CertFile),
+		fmt.Sprintf("  Windows: certutil -addstore -f ROOT %s", a.CertFile),
+		"  Firefox: Settings > Privacy & Security > Certificates > View Certificates > Authorities > Import",
+		fmt.Sprintf("  Node.js: export NODE_EXTRA_CA_CERTS=%s", a.CertFile),
+	}, "\n")
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
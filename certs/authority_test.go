@@ -0,0 +1,129 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthority_PersistsCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	created, err := LoadOrCreateAuthority(dir)
+	require.NoError(t, err)
+	assert.True(t, created.Created)
+
+	loaded, err := LoadOrCreateAuthority(dir)
+	require.NoError(t, err)
+	assert.False(t, loaded.Created)
+	assert.Equal(t, created.cert.Raw, loaded.cert.Raw)
+}
+
+func TestAuthority_MissingCAFile(t *testing.T) {
+	for _, missing := range []string{caCertFile, caKeyFile} {
+		t.Run(missing, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "certs")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			created, err := LoadOrCreateAuthority(dir)
+			require.NoError(t, err)
+			certPEM, err := ioutil.ReadFile(created.CertFile)
+			require.NoError(t, err)
+			require.NoError(t, os.Remove(filepath.Join(dir, missing)))
+
+			_, err = LoadOrCreateAuthority(dir)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), filepath.Join(dir, missing)+" is missing")
+
+			// the CA certificate clients trust isn't replaced
+			if missing == caKeyFile {
+				current, err := ioutil.ReadFile(created.CertFile)
+				require.NoError(t, err)
+				assert.Equal(t, certPEM, current)
+			}
+		})
+	}
+}
+
+func TestAuthority_GetCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, err := LoadOrCreateAuthority(dir)
+	require.NoError(t, err)
+
+	pemBytes, err := ioutil.ReadFile(a.CertFile)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(pemBytes))
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: a.GetCertificate})
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	for _, name := range []string{"local.example.com", "shop.local.example.com"} {
+		t.Run(name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{ServerName: name, RootCAs: roots})
+			require.NoError(t, err)
+			_ = conn.Close()
+		})
+	}
+
+	t.Run("no SNI", func(t *testing.T) {
+		cert, err := a.GetCertificate(&tls.ClientHelloInfo{})
+		require.NoError(t, err)
+		_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "127.0.0.1", Roots: roots})
+		assert.NoError(t, err)
+		assert.Contains(t, cert.Leaf.IPAddresses, net.IPv6loopback)
+	})
+
+	first, _ := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "local.example.com"})
+	second, _ := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "LOCAL.example.com"})
+	assert.Same(t, first, second)
+}
+
+func TestAuthority_GetCertificate_EvictsLeastRecentlyUsed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, err := LoadOrCreateAuthority(dir)
+	require.NoError(t, err)
+	a.maxLeaves = 2
+
+	get := func(name string) *tls.Certificate {
+		cert, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		require.NoError(t, err)
+		return cert
+	}
+
+	first := get("a.example.com")
+	second := get("b.example.com")
+	assert.Same(t, first, get("a.example.com"))
+	get("c.example.com")
+
+	assert.Equal(t, 2, a.leaves.Len())
+	assert.Same(t, first, get("a.example.com"))
+	assert.NotSame(t, second, get("b.example.com"))
+}
@@ -0,0 +1,152 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/JSainsburyPLC/ui-dev-proxy/proxy"
+	"github.com/urfave/cli"
+)
+
+func ExplainCommand(confProvider domain.ConfigProvider) cli.Command {
+	return cli.Command{
+		Name:      "explain",
+		Usage:     "Explain which route a request would match, without starting the proxy",
+		ArgsUsage: "METHOD URL",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "config, c",
+				Usage:    "Load configuration from 'FILE'",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:  "default-backend-url, u",
+				Usage: "the default backend to use",
+			},
+			cli.StringFlag{
+				Name:  "profile",
+				Usage: "Use the backends of the named profile in the config",
+			},
+			cli.IntFlag{
+				Name:  "port, p",
+				Usage: "The port the request is sent to, for choosing a server",
+				Value: 8080,
+			},
+			cli.BoolFlag{
+				Name:  "enable-mocks, m",
+				Usage: "Turn on mocks",
+			},
+			cli.StringSliceFlag{
+				Name:  "header, H",
+				Usage: "Add a 'NAME: VALUE' header to the request. May be repeated",
+			},
+			cli.StringFlag{
+				Name:  "body, d",
+				Usage: "The request body",
+			},
+		},
+		Action: explainAction(confProvider),
+	}
+}
+
+func explainAction(confProvider domain.ConfigProvider) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.NewExitError("explain needs a METHOD and URL, e.g. GET /test-ui/users/info", 1)
+		}
+
+		conf, err := confProvider(c.String("config"))
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		if conf, err = conf.WithProfile(c.String("profile")); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+
+		defaultBackend, err := url.Parse(c.String("default-backend-url"))
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+
+		port := c.Int("port")
+		r, err := explainRequest(c.Args().Get(0), c.Args().Get(1), port, c.StringSlice("header"), c.String("body"))
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+
+		e := proxy.Explain(conf, defaultBackend, port, r, c.Bool("enable-mocks"))
+		printExplanation(c.App.Writer, r, e)
+		if e.Error != "" {
+			return cli.NewExitError(e.Error, 1)
+		}
+		return nil
+	}
+}
+
+// explainRequest builds the request to explain. The host is localhost on the port unless the URL has one
+func explainRequest(method string, target string, port int, headers []string, body string) (*http.Request, error) {
+	r, err := http.NewRequest(strings.ToUpper(method), target, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if r.Host == "" {
+		r.Host = fmt.Sprintf("localhost:%d", port)
+	}
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("header must be 'NAME: VALUE', got '%s'", h)
+		}
+		r.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return r, nil
+}
+
+func printExplanation(w io.Writer, r *http.Request, e proxy.Explanation) {
+	_, _ = fmt.Fprintf(w, "%s %s (host %s)\n", r.Method, r.URL.RequestURI(), r.Host)
+	if e.Server != "" {
+		_, _ = fmt.Fprintf(w, "Using routes of server '%s'\n", e.Server)
+	}
+
+	for _, route := range e.Routes {
+		name := ""
+		if route.Name != "" {
+			name = fmt.Sprintf(" '%s'", route.Name)
+		}
+		if route.Origin != "" {
+			name += " in " + route.Origin
+		}
+		if route.Matched {
+			_, _ = fmt.Fprintf(w, "route %d (%s%s): matched\n", route.Index, route.Type, name)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "route %d (%s%s): didn't match\n", route.Index, route.Type, name)
+		for _, reason := range route.Reasons {
+			_, _ = fmt.Fprintf(w, "  - %s\n", reason)
+		}
+	}
+
+	switch {
+	case e.Error != "":
+		_, _ = fmt.Fprintf(w, "Fails with 502 Bad Gateway: %s\n", e.Error)
+	case e.RouteIndex == -1:
+		if u, err := url.Parse(e.Backend); err != nil || u.Host == "" {
+			_, _ = fmt.Fprintln(w, "No route matched, proxies to the default backend (set -u to see its URL)")
+			return
+		}
+		_, _ = fmt.Fprintf(w, "No route matched, proxies to default backend %s\n", e.Backend)
+	default:
+		switch e.Route.Type {
+		case domain.RouteTypeProxy:
+			_, _ = fmt.Fprintf(w, "Proxies to %s\n", e.Backend)
+		case domain.RouteTypeRedirect:
+			_, _ = fmt.Fprintf(w, "Redirects with %d to %s\n", e.Status, e.RedirectTo)
+		case domain.RouteTypeMock:
+			_, _ = fmt.Fprintf(w, "Responds with mock, status %d\n", e.Status)
+		}
+	}
+}
@@ -1,28 +1,47 @@
 package commands
 
 import (
+	"github.com/JSainsburyPLC/ui-dev-proxy/admin"
+	"github.com/JSainsburyPLC/ui-dev-proxy/certs"
 	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
 	"github.com/JSainsburyPLC/ui-dev-proxy/proxy"
 	"github.com/urfave/cli"
 	"log"
+	"net"
 	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
 )
 
-func StartCommand(logger *log.Logger, confProvider domain.ConfigProvider) cli.Command {
+// defaultAdminHost restricts the admin API to this machine, as it can change where requests are sent
+const defaultAdminHost = "127.0.0.1"
+
+func StartCommand(
+	logger *log.Logger,
+	confProvider domain.ConfigProvider,
+	confWatcher domain.ConfigWatcher,
+	confWriter domain.ConfigWriter,
+) cli.Command {
 	return cli.Command{
 		Name:  "start",
 		Usage: "Start the proxy",
 		Flags: []cli.Flag{
 			cli.StringFlag{
-				Name:     "default-backend-url, u",
-				Usage:    "the default backend to use",
-				Required: true,
+				Name:  "default-backend-url, u",
+				Usage: "the default backend to use. Required unless the profile sets a default_backend",
 			},
 			cli.StringFlag{
 				Name:     "config, c",
 				Usage:    "Load configuration from 'FILE'",
 				Required: true,
 			},
+			cli.StringFlag{
+				Name:  "profile",
+				Usage: "Use the backends of the named profile in the config",
+			},
 			cli.IntFlag{
 				Name:  "port, p",
 				Usage: "The port to start proxy on",
@@ -44,37 +63,127 @@ func StartCommand(logger *log.Logger, confProvider domain.ConfigProvider) cli.Co
 				Name:  "tls-keyfile",
 				Usage: "Path to TLS key file",
 			},
+			cli.BoolFlag{
+				Name:  "tls-auto",
+				Usage: "Turn on TLS with certificates issued on demand by a local CA, instead of tls-certfile and tls-keyfile",
+			},
+			cli.StringFlag{
+				Name:  "tls-ca-dir",
+				Usage: "Directory to keep the local CA in (default: ~/.ui-dev-proxy)",
+			},
+			cli.BoolFlag{
+				Name:  "h2c",
+				Usage: "Serve plaintext HTTP/2 with prior knowledge as well as HTTP/1.1 when TLS isn't enabled",
+			},
+			cli.BoolTFlag{
+				Name:  "watch, w",
+				Usage: "Reload configuration when the config file or any mock body file changes (use --watch=false to disable)",
+			},
+			cli.IntFlag{
+				Name:  "admin-port",
+				Usage: "The port to start the admin API on. Disabled if not set",
+			},
+			cli.StringFlag{
+				Name:  "admin-host",
+				Usage: "The address to serve the admin API on. Anyone who can reach it can change the routes, so only set it to a non-loopback address on a trusted network",
+				Value: defaultAdminHost,
+			},
+			cli.StringFlag{
+				Name:  "access-log",
+				Usage: "Write an access log entry for every request to 'FILE', or stdout if '-'",
+			},
+			cli.StringFlag{
+				Name:  "access-log-format",
+				Usage: "Format of access log entries, json or logfmt",
+				Value: proxy.AccessLogFormatJSON,
+			},
+			cli.StringFlag{
+				Name:  "access-log-level",
+				Usage: "Only log requests at this level or above: info, warn (4xx statuses) or error (5xx statuses and proxy errors)",
+				Value: "info",
+			},
+			cli.StringFlag{
+				Name:  "record",
+				Usage: "Record proxied requests and responses as mock routes to config 'FILE'",
+			},
 		},
-		Action: startAction(logger, confProvider),
+		Action: startAction(logger, confProvider, confWatcher, confWriter),
 	}
 }
 
-func startAction(logger *log.Logger, confProvider domain.ConfigProvider) cli.ActionFunc {
+func startAction(
+	logger *log.Logger,
+	confProvider domain.ConfigProvider,
+	confWatcher domain.ConfigWatcher,
+	confWriter domain.ConfigWriter,
+) cli.ActionFunc {
 	return func(c *cli.Context) error {
 		logger.Println("Starting UI Dev Proxy...")
 
 		defaultBackendUrl := c.String("default-backend-url")
 		confFile := c.String("config")
+		profile := c.String("profile")
 		port := c.Int("port")
 		mocksEnabled := c.Bool("enable-mocks")
 		tlsEnabled := c.Bool("tls-enabled")
 		tlsCertfile := c.String("tls-certfile")
 		tlsKeyfile := c.String("tls-keyfile")
+		tlsAuto := c.Bool("tls-auto")
+		tlsCADir := c.String("tls-ca-dir")
+		h2c := c.Bool("h2c")
+		watch := c.BoolT("watch")
+		recordFile := c.String("record")
+		adminPort := c.Int("admin-port")
+		adminHost := c.String("admin-host")
+		accessLogFile := c.String("access-log")
+		accessLogFormat := c.String("access-log-format")
+		accessLogLevel := c.String("access-log-level")
 
 		logger.Printf("Default backend URL: %s\n", defaultBackendUrl)
 		logger.Printf("Config file: %s\n", confFile)
+		if profile != "" {
+			logger.Printf("Profile: %s\n", profile)
+		}
 		logger.Printf("Port: %d\n", port)
 		logger.Printf("Mocks enabled: %t\n", mocksEnabled)
-		logger.Printf("TLS enabled: %t\n", tlsEnabled)
-		if tlsEnabled {
+		logger.Printf("TLS enabled: %t\n", tlsEnabled || tlsAuto)
+		if tlsAuto {
+			logger.Println("TLS certificates: issued by local CA")
+		} else if tlsEnabled {
 			logger.Printf("TLS certfile: %s\n", tlsCertfile)
 			logger.Printf("TLS keyfile: %s\n", tlsKeyfile)
 		}
+		logger.Printf("h2c enabled: %t\n", h2c)
+		logger.Printf("Watch config: %t\n", watch)
+		if adminPort != 0 {
+			logger.Printf("Admin address: %s\n", net.JoinHostPort(adminHost, strconv.Itoa(adminPort)))
+			if ip := net.ParseIP(adminHost); adminHost != "localhost" && (ip == nil || !ip.IsLoopback()) {
+				logger.Println("WARNING: the admin API isn't restricted to this machine, so anyone who can reach it can change the routes and see the traffic")
+			}
+		}
+		if recordFile != "" {
+			logger.Printf("Recording to: %s\n", recordFile)
+		}
+		if accessLogFile != "" {
+			logger.Printf("Access log: %s (%s, %s)\n", accessLogFile, accessLogFormat, accessLogLevel)
+		}
 
 		conf, err := confProvider(confFile)
 		if err != nil {
 			return cli.NewExitError(err, 1)
 		}
+		if conf, err = conf.WithProfile(profile); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		logWarnings(logger, conf)
+		if conf.DefaultBackend != nil {
+			logger.Printf("Default backend URL from profile: %s\n", conf.DefaultBackend)
+		} else if defaultBackendUrl == "" {
+			return cli.NewExitError("default-backend-url is required, unless the profile sets a default_backend", 1)
+		}
+		for _, s := range conf.Servers {
+			logger.Printf("Server '%s': port %d, hosts %v\n", s.Name, s.Port, s.Hosts)
+		}
 
 		defaultBackend, err := url.Parse(defaultBackendUrl)
 		if err != nil {
@@ -83,14 +192,111 @@ func startAction(logger *log.Logger, confProvider domain.ConfigProvider) cli.Act
 
 		p := proxy.NewProxy(port, conf, defaultBackend, mocksEnabled, logger)
 
+		p.H2cEnabled = h2c
+
 		if tlsEnabled {
 			p.TlsEnabled = true
 			p.TlsCertFile = tlsCertfile
 			p.TlsKeyFile = tlsKeyfile
 		}
 
+		if tlsAuto || conf.UsesAutoTLS() {
+			authority, err := loadAuthority(tlsCADir)
+			if err != nil {
+				return cli.NewExitError(err, 1)
+			}
+			if authority.Created {
+				logger.Printf("Generated local CA %s\n", authority.CertFile)
+			}
+			logger.Println(authority.TrustInstructions())
+
+			p.TlsEnabled = p.TlsEnabled || tlsAuto
+			p.TlsGetCertificate = authority.GetCertificate
+		}
+
+		if accessLogFile != "" {
+			accessLog, err := openAccessLog(accessLogFile, accessLogFormat, accessLogLevel)
+			if err != nil {
+				return cli.NewExitError(err, 1)
+			}
+			p.AccessLog(accessLog)
+		}
+
+		if recordFile != "" {
+			rec := proxy.NewRecorder(recordFile, confWriter, logger)
+			p.Record(rec)
+			flushOnInterrupt(rec, logger)
+		}
+
+		if adminPort != 0 {
+			p.CaptureTraffic()
+			go admin.NewAdmin(adminHost, adminPort, p, logger).Start()
+		}
+
+		if watch {
+			go confWatcher(confFile, conf, func(newConf domain.Config) {
+				newConf, err := newConf.WithProfile(profile)
+				if err != nil {
+					logger.Printf("failed to reload config, keeping previous config: %v\n", err)
+					return
+				}
+				logWarnings(logger, newConf)
+				if p.SetConfig(newConf) {
+					logger.Println("Config reloaded, replacing the routes and chaos changed through the admin API")
+				} else {
+					logger.Println("Config reloaded")
+				}
+			}, nil)
+		}
+
 		p.Start()
 
 		return nil
 	}
 }
+
+// flushOnInterrupt writes out any recorded responses that haven't been yet when the proxy is stopped
+func flushOnInterrupt(rec *proxy.Recorder, logger *log.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if err := rec.Flush(); err != nil {
+			logger.Printf("failed to write recording: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+}
+
+func openAccessLog(path string, format string, level string) (*proxy.AccessLog, error) {
+	if path == "-" {
+		return proxy.NewAccessLog(os.Stdout, format, level)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	accessLog, err := proxy.NewAccessLog(f, format, level)
+	if err != nil {
+		_ = f.Close()
+	}
+	return accessLog, err
+}
+
+func loadAuthority(dir string) (*certs.Authority, error) {
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, ".ui-dev-proxy")
+	}
+	return certs.LoadOrCreateAuthority(dir)
+}
+
+func logWarnings(logger *log.Logger, conf domain.Config) {
+	for _, w := range conf.Warnings {
+		logger.Printf("Config warning: %v\n", w)
+	}
+}
@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/urfave/cli"
+)
+
+func ValidateCommand(confProvider domain.ConfigProvider) cli.Command {
+	return cli.Command{
+		Name:  "validate",
+		Usage: "Check the config for problems, without starting the proxy",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "config, c",
+				Usage:    "Load configuration from 'FILE'",
+				Required: true,
+			},
+			cli.BoolFlag{
+				Name:  "strict",
+				Usage: "Fail on warnings, such as unreachable routes, as well as errors",
+			},
+		},
+		Action: validateAction(confProvider),
+	}
+}
+
+func validateAction(confProvider domain.ConfigProvider) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		confFile := c.String("config")
+		w := c.App.Writer
+
+		conf, err := confProvider(confFile)
+		if err != nil {
+			if problems, ok := err.(domain.Problems); ok {
+				return cli.NewExitError(fmt.Sprintf("%s is invalid:\n%v", confFile, problems), 1)
+			}
+			return cli.NewExitError(fmt.Sprintf("%s is invalid: %v", confFile, err), 1)
+		}
+
+		for _, warning := range conf.Warnings {
+			_, _ = fmt.Fprintf(w, "warning: %v\n", warning)
+		}
+		if c.Bool("strict") && len(conf.Warnings) > 0 {
+			return cli.NewExitError(fmt.Sprintf("%s has %d warnings", confFile, len(conf.Warnings)), 1)
+		}
+
+		_, _ = fmt.Fprintf(w, "%s is valid\n", confFile)
+		return nil
+	}
+}
@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"net/url"
+)
+
+const (
+	AuthTypeBasic  = "basic"
+	AuthTypeBearer = "bearer"
+	AuthTypeOAuth2 = "oauth2"
+)
+
+// Auth sets the Authorization header of requests proxied to a route's backend
+type Auth struct {
+	// Type is basic, bearer or oauth2. Required
+	Type string `json:"type"`
+
+	// Username and Password are the credentials for basic auth
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Token is the bearer token. Alternatively it's read from TokenFile, or the TokenEnv environment variable,
+	// on every request so it can be renewed without reloading the config
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+	TokenEnv  string `json:"token_env,omitempty"`
+
+	// TokenURL is the OAuth2 token endpoint. Tokens are fetched with the client credentials grant, or the
+	// refresh token grant when RefreshToken is set, and cached until they expire
+	TokenURL     string   `json:"token_url,omitempty"`
+	ClientID     string   `json:"client_id,omitempty"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	RefreshToken string   `json:"refresh_token,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	// Params are extra form parameters for the token request, such as an audience
+	Params map[string]string `json:"params,omitempty"`
+	// TokenClientCert presents the backend transport's client certificate to the token endpoint as well, for
+	// endpoints that authenticate clients with mTLS. It's only presented to the backend otherwise
+	TokenClientCert bool `json:"token_client_cert,omitempty"`
+}
+
+func (a Auth) problems(path string) Problems {
+	var problems Problems
+	switch a.Type {
+	case AuthTypeBasic:
+		if a.Username == "" {
+			problems.add(JoinPath(path, "username"), "basic auth requires a username")
+		}
+	case AuthTypeBearer:
+		sources := 0
+		for _, source := range []string{a.Token, a.TokenFile, a.TokenEnv} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			problems.add(path, "bearer auth requires one of token, token_file or token_env")
+		}
+	case AuthTypeOAuth2:
+		if u, err := url.Parse(a.TokenURL); err != nil || u.Host == "" {
+			problems.add(JoinPath(path, "token_url"), "oauth2 auth requires an absolute token_url")
+		}
+		if a.ClientID == "" {
+			problems.add(JoinPath(path, "client_id"), "oauth2 auth requires a client_id")
+		}
+	default:
+		problems.add(JoinPath(path, "type"), "unknown auth type '%s'", a.Type)
+	}
+	return problems
+}
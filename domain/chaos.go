@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Chaos simulates slow and failing responses, to test loading states and error handling
+type Chaos struct {
+	// Latency delays the response by a fixed duration
+	Latency *Duration `json:"latency,omitempty"`
+	// MaxLatency makes the delay random, between Latency and MaxLatency
+	MaxLatency *Duration `json:"max_latency,omitempty"`
+	// BytesPerSecond throttles writing the response body to this rate
+	BytesPerSecond int `json:"bytes_per_second,omitempty"`
+	// ErrorRate is the probability, from 0 to 1, of responding with ErrorStatus instead
+	ErrorRate float64 `json:"error_rate,omitempty"`
+	// ErrorStatus is the status code of error responses. Defaults to 500
+	ErrorStatus int `json:"error_status,omitempty"`
+	// ResetRate is the probability, from 0 to 1, of closing the connection without responding
+	ResetRate float64 `json:"reset_rate,omitempty"`
+	// TruncateRate is the probability, from 0 to 1, of closing the connection part way through the body
+	TruncateRate float64 `json:"truncate_rate,omitempty"`
+}
+
+// Validate checks the chaos settings are in range
+func (c Chaos) Validate() error {
+	if c.Latency.Value() < 0 || c.MaxLatency.Value() < 0 {
+		return errors.New("chaos latency must not be negative")
+	}
+	if c.MaxLatency != nil && c.MaxLatency.Value() < c.Latency.Value() {
+		return errors.New("chaos max_latency must not be less than latency")
+	}
+	if c.BytesPerSecond < 0 {
+		return errors.New("chaos bytes_per_second must not be negative")
+	}
+	for name, rate := range map[string]float64{"error_rate": c.ErrorRate, "reset_rate": c.ResetRate, "truncate_rate": c.TruncateRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("chaos %s must be between 0 and 1", name)
+		}
+	}
+	if c.ErrorStatus != 0 && (c.ErrorStatus < 100 || c.ErrorStatus > 599) {
+		return fmt.Errorf("invalid chaos error_status %d", c.ErrorStatus)
+	}
+	return nil
+}
+
+// Duration is a time.Duration that's configured as a string such as "1.5s" or "300ms", or a number of milliseconds
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var ms float64
+	if err := json.Unmarshal(data, &ms); err == nil {
+		d.Duration = time.Duration(ms * float64(time.Millisecond))
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("duration must be a string or a number of milliseconds")
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Value returns the duration, or zero if it isn't set
+func (d *Duration) Value() time.Duration {
+	if d == nil {
+		return 0
+	}
+	return d.Duration
+}
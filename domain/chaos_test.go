@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuration_UnmarshalJSON(t *testing.T) {
+	tests := map[string]time.Duration{
+		`"1.5s"`:  1500 * time.Millisecond,
+		`"300ms"`: 300 * time.Millisecond,
+		`250`:     250 * time.Millisecond,
+	}
+	for data, expected := range tests {
+		t.Run(data, func(t *testing.T) {
+			var d Duration
+			assert.NoError(t, json.Unmarshal([]byte(data), &d))
+			assert.Equal(t, expected, d.Duration)
+		})
+	}
+
+	var d Duration
+	assert.Error(t, json.Unmarshal([]byte(`"soon"`), &d))
+}
+
+func TestChaos_Validate(t *testing.T) {
+	second := &Duration{Duration: time.Second}
+	tests := map[string]struct {
+		chaos Chaos
+		valid bool
+	}{
+		"valid":                       {Chaos{Latency: second, MaxLatency: second, ErrorRate: 0.5}, true},
+		"max latency less than min":   {Chaos{Latency: second, MaxLatency: &Duration{}}, false},
+		"rate greater than 1":         {Chaos{ResetRate: 1.5}, false},
+		"negative rate":               {Chaos{TruncateRate: -1}, false},
+		"invalid error status":        {Chaos{ErrorRate: 1, ErrorStatus: 1000}, false},
+		"negative bandwidth throttle": {Chaos{BytesPerSecond: -1}, false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.valid, test.chaos.Validate() == nil)
+		})
+	}
+}
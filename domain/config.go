@@ -14,18 +14,66 @@ const (
 
 type Config struct {
 	Routes []Route `json:"routes"`
+	// Chaos applies to requests to the default backend, and routes without their own chaos settings
+	Chaos *Chaos `json:"chaos,omitempty"`
+	// CORS applies to requests to the default backend, and routes without their own CORS policy
+	CORS *CORS `json:"cors,omitempty"`
+	// Servers are additional listeners and virtual servers, each with their own default backend and routes
+	Servers []Server `json:"servers,omitempty"`
+	// Profiles are named sets of backends to switch between, by name
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	// Transport configures the connections to every backend. Backends can override it with their own
+	Transport *Transport `json:"transport,omitempty"`
+	// Include lists config files, or globs of them, whose routes and servers are added after this file's
+	Include []string `json:"include,omitempty"`
+
+	// DefaultBackend replaces the default backend given on the command line, when set by a profile
+	DefaultBackend *Backend `json:"-"`
+	// Files lists the config file and any body files that were read while loading it
+	Files []string `json:"-"`
+	// Warnings are problems found while loading the config that don't stop it being used
+	Warnings Problems `json:"-"`
 }
 
 type Route struct {
+	// Name identifies the route in logs. Optional
+	Name                      string            `json:"name,omitempty"`
 	Type                      string            `json:"type"`
-	PathPattern               *PathPattern      `json:"path_pattern"`
-	Backend                   *Backend          `json:"backend"`
-	Mock                      *Mock             `json:"mock"`
-	Rewrite                   []Rewrite         `json:"rewrite"`
-	Redirect                  *Redirect         `json:"redirect"`
-	ProxyPassHeaders          map[string]string `json:"proxy_pass_headers"`
-	ProxyResponseHeaders      map[string]string `json:"proxy_response_headers"`
-	ProxyResponseReplacements map[string]string `json:"proxy_response_replacements"`
+	PathPattern               *PathPattern      `json:"path_pattern,omitempty"`
+	Backend                   *Backend          `json:"backend,omitempty"`
+	Mock                      *Mock             `json:"mock,omitempty"`
+	Rewrite                   []Rewrite         `json:"rewrite,omitempty"`
+	Redirect                  *Redirect         `json:"redirect,omitempty"`
+	ProxyPassHeaders          map[string]string `json:"proxy_pass_headers,omitempty"`
+	ProxyResponseHeaders      map[string]string `json:"proxy_response_headers,omitempty"`
+	ProxyResponseReplacements map[string]string `json:"proxy_response_replacements,omitempty"`
+	Chaos                     *Chaos            `json:"chaos,omitempty"`
+	// Cookies rewrites the cookies set by the backend, and sent to it. Optional
+	Cookies *Cookies `json:"cookies,omitempty"`
+	// CORS replaces the config's CORS policy for requests matching the route. Optional
+	CORS *CORS `json:"cors,omitempty"`
+	// Auth sets the Authorization header of requests proxied to the backend. Optional
+	Auth *Auth `json:"auth,omitempty"`
+	// Match narrows the requests the route matches, in addition to its path_pattern or mock request
+	Match *MatchRequest `json:"match,omitempty"`
+	// Origin is where the route was declared, if it was in an included file
+	Origin *Origin `json:"-"`
+}
+
+// Origin is the included file a route or server was declared in, and its JSON path in that file
+type Origin struct {
+	File string
+	Path string
+}
+
+// Validate checks the whole config, returning every problem that stops it being used as Problems
+func (c Config) Validate() error {
+	return c.Problems().err()
+}
+
+// Validate checks the route is complete enough to be matched and served
+func (r Route) Validate() error {
+	return r.problems("").err()
 }
 
 type Rewrite struct {
@@ -59,14 +107,37 @@ func (p *PathPattern) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (p PathPattern) MarshalJSON() ([]byte, error) {
+	if p.Regexp == nil {
+		return json.Marshal("")
+	}
+	return json.Marshal(p.String())
+}
+
+// Backend is a URL requests are proxied to. It's configured as a URL string, or as an object with the URL
+// and the transport settings to use for it
 type Backend struct {
 	*url.URL
+	// Transport overrides the config's transport settings for this backend. Optional
+	Transport *Transport
+}
+
+// backendObject is the object form of a backend
+type backendObject struct {
+	URL       string     `json:"url"`
+	Transport *Transport `json:"transport,omitempty"`
 }
 
 func (p *Backend) UnmarshalJSON(data []byte) error {
 	var s string
-	err := json.Unmarshal(data, &s)
-	if err != nil {
+	if len(data) > 0 && data[0] == '{' {
+		var obj backendObject
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+		s = obj.URL
+		p.Transport = obj.Transport
+	} else if err := json.Unmarshal(data, &s); err != nil {
 		return err
 	}
 
@@ -80,5 +151,23 @@ func (p *Backend) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (p Backend) MarshalJSON() ([]byte, error) {
+	s := ""
+	if p.URL != nil {
+		s = p.String()
+	}
+	if p.Transport != nil {
+		return json.Marshal(backendObject{URL: s, Transport: p.Transport})
+	}
+	return json.Marshal(s)
+}
+
 // TODO: the path arg here is a leaky abstraction - fix it
 type ConfigProvider func(path string) (Config, error)
+
+// ConfigWriter writes conf out to path in a form that ConfigProvider can load back
+type ConfigWriter func(path string, conf Config) error
+
+// ConfigWatcher watches the config at path, and every file it references, calling onChange with the
+// reloaded config whenever any of them change. It blocks until stop is closed
+type ConfigWatcher func(path string, conf Config, onChange func(Config), stop <-chan struct{})
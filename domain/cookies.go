@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"sort"
+	"strings"
+)
+
+// Cookies rewrites the cookies set by a route's backend, so the browser accepts them from the proxy, and the
+// cookies sent back to the backend. Each attribute is left as the backend set it unless configured
+type Cookies struct {
+	// Domain replaces the Domain attribute of cookies that have one. An empty string removes it, so cookies are
+	// set on the proxy's host
+	Domain *string `json:"domain,omitempty"`
+	// Path replaces the Path attribute. An empty string removes it
+	Path *string `json:"path,omitempty"`
+	// Secure adds the Secure attribute when true, and removes it when false, for a proxy served over plain HTTP
+	Secure *bool `json:"secure,omitempty"`
+	// SameSite replaces the SameSite attribute with lax, strict or none. An empty string removes it
+	SameSite *string `json:"same_site,omitempty"`
+	// Rename renames cookies set by the backend, from the backend's name to the name used by the browser. Cookies
+	// sent by the browser are renamed back to the backend's name
+	Rename map[string]string `json:"rename,omitempty"`
+}
+
+func (c Cookies) problems(path string) Problems {
+	var problems Problems
+	if c.SameSite != nil {
+		switch strings.ToLower(*c.SameSite) {
+		case "", "lax", "strict", "none":
+		default:
+			problems.add(JoinPath(path, "same_site"), "invalid same_site '%s'", *c.SameSite)
+		}
+		if strings.EqualFold(*c.SameSite, "none") && c.Secure != nil && !*c.Secure {
+			problems.warn(path, "browsers reject SameSite=None cookies that aren't Secure")
+		}
+	}
+
+	names := make([]string, 0, len(c.Rename))
+	for name := range c.Rename {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	renamedFrom := map[string]string{}
+	for _, name := range names {
+		to := c.Rename[name]
+		renamePath := JoinPath(JoinPath(path, "rename"), name)
+		if !isCookieName(name) || !isCookieName(to) {
+			problems.add(renamePath, "invalid cookie name")
+			continue
+		}
+		if from, ok := renamedFrom[to]; ok {
+			problems.add(renamePath, "cookies '%s' and '%s' are both renamed to '%s'", from, name, to)
+		}
+		renamedFrom[to] = name
+	}
+	return problems
+}
+
+// isCookieName checks name is a valid cookie name, which is a token as defined by RFC 7230
+func isCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
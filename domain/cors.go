@@ -0,0 +1,63 @@
+package domain
+
+import (
+	"fmt"
+	"path"
+)
+
+// CORS answers preflight requests, and sets the Access-Control-* headers of responses to cross-origin requests
+type CORS struct {
+	// AllowOrigins are the origins allowed to make requests, which may contain * wildcards such as
+	// http://localhost:*. Defaults to any origin
+	AllowOrigins []string `json:"allow_origins,omitempty"`
+	// AllowMethods are the methods allowed by preflight responses. Defaults to the method requested
+	AllowMethods []string `json:"allow_methods,omitempty"`
+	// AllowHeaders are the request headers allowed by preflight responses. Defaults to the headers requested
+	AllowHeaders []string `json:"allow_headers,omitempty"`
+	// ExposeHeaders are the response headers the page's scripts can read
+	ExposeHeaders []string `json:"expose_headers,omitempty"`
+	// AllowCredentials allows requests with cookies. The request's origin is reflected, as * isn't allowed with
+	// credentials
+	AllowCredentials bool `json:"allow_credentials,omitempty"`
+	// MaxAge is how long browsers can cache preflight responses for
+	MaxAge *Duration `json:"max_age,omitempty"`
+}
+
+// AllowsOrigin checks if requests from origin are allowed
+func (c CORS) AllowsOrigin(origin string) bool {
+	if len(c.AllowOrigins) == 0 {
+		return true
+	}
+	for _, pattern := range c.AllowOrigins {
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAnyOrigin checks if requests are allowed from any origin, so the response doesn't depend on the origin
+func (c CORS) AllowsAnyOrigin() bool {
+	if len(c.AllowOrigins) == 0 {
+		return true
+	}
+	for _, pattern := range c.AllowOrigins {
+		if pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CORS) problems(p string) Problems {
+	var problems Problems
+	for i, pattern := range c.AllowOrigins {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems.add(JoinPath(p, fmt.Sprintf("allow_origins[%d]", i)), "invalid origin pattern '%s'", pattern)
+		}
+	}
+	if c.MaxAge.Value() < 0 {
+		problems.add(JoinPath(p, "max_age"), "max_age must not be negative")
+	}
+	return problems
+}
@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS_AllowsOrigin(t *testing.T) {
+	assert.True(t, CORS{}.AllowsOrigin("https://www.example.com"))
+	assert.True(t, CORS{}.AllowsAnyOrigin())
+
+	cors := CORS{AllowOrigins: []string{"http://localhost:*", "https://*.example.com"}}
+	assert.True(t, cors.AllowsOrigin("http://localhost:3000"))
+	assert.True(t, cors.AllowsOrigin("https://www.example.com"))
+	assert.False(t, cors.AllowsOrigin("https://example.com"))
+	assert.False(t, cors.AllowsOrigin("http://127.0.0.1:3000"))
+	assert.False(t, cors.AllowsAnyOrigin())
+
+	assert.Equal(t, Problems{
+		{Path: "cors.allow_origins[0]", Message: "invalid origin pattern 'http://[localhost'"},
+	}, CORS{AllowOrigins: []string{"http://[localhost"}}.problems("cors"))
+}
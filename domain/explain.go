@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// explainer describes why a matcher didn't match a request
+type explainer func(r *http.Request, mock Mock) string
+
+// Explain returns why the mock doesn't match the request, with a reason for each matcher that failed,
+// or nil if it matches
+func (m Matcher) Explain(r *http.Request, mock Mock) []string {
+	var reasons []string
+	for i, match := range m.matchers {
+		if !match(r, mock) {
+			reasons = append(reasons, m.explainers[i](r, mock))
+		}
+	}
+	return reasons
+}
+
+// ExplainRequest returns why the request doesn't match match, or nil if it matches
+func (m Matcher) ExplainRequest(r *http.Request, match MatchRequest) []string {
+	return m.Explain(r, Mock{MatchRequest: match})
+}
+
+var explainHost explainer = func(r *http.Request, mock Mock) string {
+	return fmt.Sprintf("host '%s' doesn't match '%s'", r.Host, mock.MatchRequest.Host)
+}
+
+var explainMethod explainer = func(r *http.Request, mock Mock) string {
+	return fmt.Sprintf("method %s isn't %s", r.Method, mock.MatchRequest.Method)
+}
+
+var explainPath explainer = func(r *http.Request, mock Mock) string {
+	return fmt.Sprintf("path '%s' doesn't match '%s'", r.URL.Path, mock.MatchRequest.Path)
+}
+
+var explainQuery explainer = func(r *http.Request, mock Mock) string {
+	return fmt.Sprintf("query '%s' doesn't match '%s'", r.URL.RawQuery, mock.MatchRequest.Query)
+}
+
+var explainHeaders explainer = func(r *http.Request, mock Mock) string {
+	var reasons []string
+	for _, name := range sortedKeys(mock.MatchRequest.Headers) {
+		pattern := mock.MatchRequest.Headers[name]
+		received := r.Header[textproto.CanonicalMIMEHeaderKey(name)]
+		if !matchesValues(pattern, received) {
+			reasons = append(reasons, explainValues("header", name, pattern, received))
+		}
+	}
+	return strings.Join(reasons, ", ")
+}
+
+var explainCookies explainer = func(r *http.Request, mock Mock) string {
+	received := map[string][]string{}
+	for _, c := range r.Cookies() {
+		received[c.Name] = append(received[c.Name], c.Value)
+	}
+
+	var reasons []string
+	for _, name := range sortedKeys(mock.MatchRequest.Cookies) {
+		pattern := mock.MatchRequest.Cookies[name]
+		if !matchesValues(pattern, received[name]) {
+			reasons = append(reasons, explainValues("cookie", name, pattern, received[name]))
+		}
+	}
+	return strings.Join(reasons, ", ")
+}
+
+func explainValues(kind string, name string, pattern *string, received []string) string {
+	switch {
+	case pattern == nil:
+		return fmt.Sprintf("%s %s is present", kind, name)
+	case len(received) == 0:
+		return fmt.Sprintf("%s %s is missing", kind, name)
+	default:
+		return fmt.Sprintf("%s %s '%s' doesn't match '%s'", kind, name, strings.Join(received, ", "), *pattern)
+	}
+}
+
+var explainBody explainer = func(r *http.Request, mock Mock) string {
+	if _, ok := readBody(r); !ok {
+		return "body is empty"
+	}
+	if mock.MatchRequest.BodyMatch == BodyMatchPartial {
+		return "body doesn't contain the JSON of the mock body"
+	}
+	return "body doesn't equal, or match, the mock body"
+}
+
+var explainBodyExpressions explainer = func(r *http.Request, mock Mock) string {
+	body, ok := readBody(r)
+	if !ok {
+		return "body is empty"
+	}
+
+	var reqJSON interface{}
+	if err := json.Unmarshal(body, &reqJSON); err != nil {
+		return "body isn't JSON"
+	}
+
+	var failed []string
+	for _, expr := range mock.MatchRequest.BodyExpressions {
+		e, err := parseBodyExpression(expr)
+		if err != nil || !e.Matches(reqJSON) {
+			failed = append(failed, fmt.Sprintf("'%s'", expr))
+		}
+	}
+	return "body doesn't satisfy " + strings.Join(failed, ", ")
+}
+
+func explainState(scenarios *Scenarios) explainer {
+	return func(r *http.Request, mock Mock) string {
+		return fmt.Sprintf("scenario '%s' is in state '%s', not '%s'", mock.Scenario, scenarios.State(mock.Scenario), mock.State)
+	}
+}
+
+func sortedKeys(m map[string]*string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatcher_Explain(t *testing.T) {
+	m := NewMatcher()
+	absent := "x"
+	mock := Mock{MatchRequest: MatchRequest{
+		Method:  http.MethodPost,
+		Path:    "^/api/orders$",
+		Headers: map[string]*string{"Authorization": &absent, "X-Feature": nil},
+	}}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/orders", strings.NewReader(""))
+	r.Header.Set("X-Feature", "on")
+
+	assert.Equal(t, []string{
+		"method GET isn't POST",
+		"header Authorization is missing, header X-Feature is present",
+	}, m.Explain(r, mock))
+}
+
+func TestMatcher_Explain_Matches(t *testing.T) {
+	m := NewMatcher()
+	r := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+
+	assert.Nil(t, m.ExplainRequest(r, MatchRequest{Path: "^/api/.*"}))
+}
@@ -0,0 +1,223 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// bodyExpression is a JSONPath style expression that is checked against a request's JSON body, e.g.
+//
+//	$.items[0].sku == "123"
+//	$.customer.email =~ "@example\\.com$"
+//	$.items[*].promotion
+//
+// The path supports child names (.name or ['name']), array indexes ([0]) and wildcards (.* or [*]).
+// The operator is == (any selected value equals the JSON literal), != (no selected value equals it)
+// or =~ (any selected string matches the regex). Without an operator the path must select something
+type bodyExpression struct {
+	path  []pathSegment
+	op    string
+	value interface{}
+	re    *regexp.Regexp
+}
+
+type pathSegment struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func parseBodyExpression(expr string) (bodyExpression, error) {
+	s := strings.TrimSpace(expr)
+	if !strings.HasPrefix(s, "$") {
+		return bodyExpression{}, fmt.Errorf("body expression '%s' must start with '$'", expr)
+	}
+
+	path, rest, err := parsePath(s[1:])
+	if err != nil {
+		return bodyExpression{}, fmt.Errorf("invalid body expression '%s': %w", expr, err)
+	}
+
+	e := bodyExpression{path: path}
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return e, nil
+	}
+
+	if len(rest) < 2 {
+		return bodyExpression{}, fmt.Errorf("invalid body expression '%s': unexpected '%s'", expr, rest)
+	}
+	e.op = rest[:2]
+	literal := strings.TrimSpace(rest[2:])
+
+	switch e.op {
+	case "==", "!=":
+		if err := json.Unmarshal([]byte(literal), &e.value); err != nil {
+			return bodyExpression{}, fmt.Errorf("invalid body expression '%s': value must be JSON: %w", expr, err)
+		}
+	case "=~":
+		var pattern string
+		if err := json.Unmarshal([]byte(literal), &pattern); err != nil {
+			return bodyExpression{}, fmt.Errorf("invalid body expression '%s': regex must be a JSON string", expr)
+		}
+		e.re, err = regexp.Compile(pattern)
+		if err != nil {
+			return bodyExpression{}, fmt.Errorf("invalid body expression '%s': %w", expr, err)
+		}
+	default:
+		return bodyExpression{}, fmt.Errorf("invalid body expression '%s': unknown operator '%s'", expr, e.op)
+	}
+
+	return e, nil
+}
+
+// parsePath parses path segments from the start of s, returning the remainder of s after the path
+func parsePath(s string) ([]pathSegment, string, error) {
+	var path []pathSegment
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			if strings.HasPrefix(s, "*") {
+				path = append(path, pathSegment{wildcard: true})
+				s = s[1:]
+				continue
+			}
+			end := strings.IndexAny(s, ".[ \t=!")
+			if end == -1 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, "", fmt.Errorf("missing name after '.'")
+			}
+			path = append(path, pathSegment{name: s[:end]})
+			s = s[end:]
+		case '[':
+			end := strings.Index(s, "]")
+			if end == -1 {
+				return nil, "", fmt.Errorf("missing ']'")
+			}
+			inner := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+
+			switch {
+			case inner == "*":
+				path = append(path, pathSegment{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				path = append(path, pathSegment{name: inner[1 : len(inner)-1]})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil || i < 0 {
+					return nil, "", fmt.Errorf("invalid index '%s'", inner)
+				}
+				path = append(path, pathSegment{index: i, isIndex: true})
+			}
+		default:
+			return path, s, nil
+		}
+	}
+	return path, s, nil
+}
+
+// Matches checks the expression against a decoded JSON body
+func (e bodyExpression) Matches(body interface{}) bool {
+	nodes := e.selectNodes(body)
+
+	switch e.op {
+	case "":
+		return len(nodes) > 0
+	case "==":
+		for _, n := range nodes {
+			if reflect.DeepEqual(n, e.value) {
+				return true
+			}
+		}
+		return false
+	case "!=":
+		for _, n := range nodes {
+			if reflect.DeepEqual(n, e.value) {
+				return false
+			}
+		}
+		return true
+	case "=~":
+		for _, n := range nodes {
+			if s, ok := n.(string); ok && e.re.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func (e bodyExpression) selectNodes(body interface{}) []interface{} {
+	nodes := []interface{}{body}
+	for _, seg := range e.path {
+		var next []interface{}
+		for _, n := range nodes {
+			switch v := n.(type) {
+			case map[string]interface{}:
+				if seg.wildcard {
+					for _, child := range v {
+						next = append(next, child)
+					}
+				} else if child, ok := v[seg.name]; ok && !seg.isIndex {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if seg.wildcard {
+					next = append(next, v...)
+				} else if seg.isIndex && seg.index < len(v) {
+					next = append(next, v[seg.index])
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// containsJSON checks actual contains everything in expected. Objects must contain every expected
+// field, arrays must contain a match for every expected element, and anything else must be equal
+func containsJSON(actual interface{}, expected interface{}) bool {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, ev := range e {
+			av, ok := a[k]
+			if !ok || !containsJSON(av, ev) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, ev := range e {
+			found := false
+			for _, av := range a {
+				if containsJSON(av, ev) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(actual, expected)
+	}
+}
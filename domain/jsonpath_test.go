@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const basketJSON = `{
+	"customer": {"email": "peter@example.com", "loyalty": true},
+	"items": [
+		{"sku": "123", "quantity": 2},
+		{"sku": "456", "quantity": 1, "promotion": "3for2"}
+	]
+}`
+
+func TestBodyExpression_Matches(t *testing.T) {
+	tests := map[string]struct {
+		expr     string
+		expected bool
+	}{
+		"equals string":              {`$.items[0].sku == "123"`, true},
+		"equals string - different":  {`$.items[0].sku == "456"`, false},
+		"equals number":              {`$.items[1].quantity == 1`, true},
+		"equals bool":                {`$.customer.loyalty == true`, true},
+		"equals object":              {`$.items[0] == {"sku": "123", "quantity": 2}`, true},
+		"bracket name":               {`$['customer']["email"] == "peter@example.com"`, true},
+		"not equals":                 {`$.items[0].sku != "456"`, true},
+		"not equals - same":          {`$.items[0].sku != "123"`, false},
+		"regex":                      {`$.customer.email =~ "@example\\.com$"`, true},
+		"regex - no match":           {`$.customer.email =~ "@sainsburys"`, false},
+		"wildcard":                   {`$.items[*].sku == "456"`, true},
+		"dot wildcard":               {`$.customer.* == true`, true},
+		"exists":                     {`$.items[*].promotion`, true},
+		"exists - missing":           {`$.items[0].promotion`, false},
+		"index out of range":         {`$.items[5].sku == "123"`, false},
+		"name on array has no match": {`$.items.sku == "123"`, false},
+	}
+	var body interface{}
+	if err := json.Unmarshal([]byte(basketJSON), &body); err != nil {
+		t.Fatal(err)
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := parseBodyExpression(test.expr)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, e.Matches(body))
+		})
+	}
+}
+
+func TestBodyExpression_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		`items[0].sku == "123"`,
+		`$.items[x].sku == "123"`,
+		`$.items[0.sku`,
+		`$.items[0].sku == 123abc`,
+		`$.items[0].sku =~ 123`,
+		`$.items[0].sku =~ "("`,
+		`$.items[0].sku >= 1`,
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := parseBodyExpression(expr)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -3,8 +3,11 @@ package domain
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"reflect"
 	"regexp"
@@ -14,44 +17,135 @@ import (
 type Mock struct {
 	MatchRequest MatchRequest `json:"request"`
 	Response     Response     `json:"response"`
+	// Scenario names the scenario this mock belongs to. Optional
+	Scenario string `json:"scenario,omitempty"`
+	// State the scenario must be in for this mock to match. Matches any state if empty
+	State string `json:"state,omitempty"`
+	// NewState the scenario moves to after this mock responds. Optional
+	NewState string `json:"new_state,omitempty"`
 }
 
+const (
+	// BodyMatchExact matches the body as a string, a regex or an equal JSON object
+	BodyMatchExact = "exact"
+	// BodyMatchPartial matches when the request JSON body contains all of the mock's JSON body
+	BodyMatchPartial = "partial"
+)
+
 // MatchRequest is the user defined matcher that we check incoming requests against.
 // A mock is considered to match if MatchRequest is equal to the incoming request
 type MatchRequest struct {
-	Method string `json:"method"`
-	Path   string `json:"path"`
-	Query  string `json:"query"`
-	Body   string `json:"body"`
+	// Host matches the Host header exactly or by regex, with or without the port
+	Host   string `json:"host,omitempty"`
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Query  string `json:"query,omitempty"`
+	Body   string `json:"body,omitempty"`
+	// BodyMatch selects how Body is compared, either BodyMatchExact (the default) or BodyMatchPartial
+	BodyMatch string `json:"body_match,omitempty"`
+	// BodyExpressions are JSONPath style expressions that the request JSON body must all satisfy
+	BodyExpressions []string `json:"body_expressions,omitempty"`
+	// Headers matches header values exactly or by regex. A null value matches when the header is absent
+	Headers map[string]*string `json:"headers,omitempty"`
+	// Cookies matches cookie values exactly or by regex. A null value matches when the cookie is absent
+	Cookies map[string]*string `json:"cookies,omitempty"`
 }
 
 // Response is returned to the consumer if the MockRequest matches. If multiple requests match
 // the first Response is returned
 type Response struct {
-	Status  int               `json:"status"`
-	Body    string            `json:"body"`
-	Headers map[string]string `json:"headers"`
-	Cookies []Cookie          `json:"cookies"`
+	Status  int                     `json:"status"`
+	Body    string                  `json:"body"`
+	Headers map[string]HeaderValues `json:"headers,omitempty"`
+	Cookies []Cookie                `json:"cookies,omitempty"`
+	// Template renders the body and header values as Go templates with data from the request
+	Template bool `json:"template,omitempty"`
+	// WebSocket upgrades the request to a websocket and sends a sequence of messages, instead of the body
+	WebSocket *WebSocket `json:"websocket,omitempty"`
+	// ContentType is inferred from the extension when the body is read from a file. It's
+	// overridden by a Content-Type in Headers
+	ContentType string `json:"-"`
+	// EncodedBodies are pre-encoded variants of a body read from a file, by content coding
+	EncodedBodies map[string]string `json:"-"`
+}
+
+// HeaderValues are the values of a response header. In config it's either a single string or a list
+// of strings, for headers with multiple values
+type HeaderValues []string
+
+func (h *HeaderValues) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*h = HeaderValues{s}
+		return nil
+	}
+
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return errors.New("header value must be a string or a list of strings")
+	}
+	*h = values
+
+	return nil
+}
+
+func (h HeaderValues) MarshalJSON() ([]byte, error) {
+	if len(h) == 1 {
+		return json.Marshal(h[0])
+	}
+	return json.Marshal([]string(h))
 }
 
 // Cookie is added to a `Set-Cookie` header in the mock response
 type Cookie struct {
 	Name   string `json:"name"`
 	Value  string `json:"value"`
-	MaxAge int    `json:"maxAge"`
+	MaxAge int    `json:"maxAge,omitempty"`
 }
 
 // Matcher is the core service that orchestrates comparing the incoming request against the matchers
 // and returning the response if the request is matched
 type Matcher struct {
 	matchers []matcher
+	// explainers describe why the matcher at the same index didn't match
+	explainers []explainer
+	scenarios  *Scenarios
 }
 
 // NewMatcher creates a Matcher composed of all of the registered matchers
 func NewMatcher() Matcher {
-	return Matcher{
-		matchers: []matcher{matchesMethod, matchesPath, matchesQuery, matchesBody},
+	scenarios := NewScenarios()
+	checks := []struct {
+		match   matcher
+		explain explainer
+	}{
+		{matchesHost, explainHost},
+		{matchesMethod, explainMethod},
+		{matchesPath, explainPath},
+		{matchesQuery, explainQuery},
+		{matchesHeaders, explainHeaders},
+		{matchesCookies, explainCookies},
+		{matchesBody, explainBody},
+		{matchesBodyExpressions, explainBodyExpressions},
+		{matchesState(scenarios), explainState(scenarios)},
 	}
+
+	m := Matcher{scenarios: scenarios}
+	for _, c := range checks {
+		m.matchers = append(m.matchers, c.match)
+		m.explainers = append(m.explainers, c.explain)
+	}
+	return m
+}
+
+// Scenarios returns the scenario states the matcher matches mocks against
+func (m Matcher) Scenarios() *Scenarios {
+	return m.scenarios
+}
+
+// MatchRequest matches a request against match, as if it were a mock without a scenario
+func (m Matcher) MatchRequest(r *http.Request, match MatchRequest) bool {
+	return m.Match(r, Mock{MatchRequest: match})
 }
 
 // Match matches a mock against all matchers
@@ -124,6 +218,67 @@ var matchesQuery matcher = func(r *http.Request, mock Mock) bool {
 	return true
 }
 
+var matchesHeaders matcher = func(r *http.Request, mock Mock) bool {
+	for name, pattern := range mock.MatchRequest.Headers {
+		if !matchesValues(pattern, r.Header[textproto.CanonicalMIMEHeaderKey(name)]) {
+			return false
+		}
+	}
+	return true
+}
+
+var matchesCookies matcher = func(r *http.Request, mock Mock) bool {
+	if len(mock.MatchRequest.Cookies) == 0 {
+		return true
+	}
+
+	received := map[string][]string{}
+	for _, c := range r.Cookies() {
+		received[c.Name] = append(received[c.Name], c.Value)
+	}
+
+	for name, pattern := range mock.MatchRequest.Cookies {
+		if !matchesValues(pattern, received[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesValues checks any of the received values equals, or matches the regex, pattern.
+// A nil pattern only matches when there are no received values
+func matchesValues(pattern *string, received []string) bool {
+	if pattern == nil {
+		return len(received) == 0
+	}
+
+	for _, value := range received {
+		if value == *pattern {
+			return true
+		}
+		match, err := regexp.MatchString(*pattern, value)
+		if err != nil {
+			return false
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+var matchesHost matcher = func(r *http.Request, mock Mock) bool {
+	if mock.MatchRequest.Host == "" {
+		return true
+	}
+	// match with or without the port
+	received := []string{r.Host}
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		received = append(received, host)
+	}
+	return matchesValues(&mock.MatchRequest.Host, received)
+}
+
 var matchesMethod matcher = func(r *http.Request, mock Mock) bool {
 	if r.Method == mock.MatchRequest.Method {
 		return true
@@ -134,28 +289,36 @@ var matchesMethod matcher = func(r *http.Request, mock Mock) bool {
 	return false
 }
 
-var matchesBody = func(req *http.Request, mock Mock) bool {
+func matchesState(scenarios *Scenarios) matcher {
+	return func(r *http.Request, mock Mock) bool {
+		if mock.Scenario == "" || mock.State == "" {
+			return true
+		}
+		return scenarios.State(mock.Scenario) == mock.State
+	}
+}
+
+var matchesBody matcher = func(req *http.Request, mock Mock) bool {
 	mockBody := mock.MatchRequest.Body
 
 	if len(mockBody) == 0 {
 		return true
 	}
 
-	if req.Body == nil {
+	body, ok := readBody(req)
+	if !ok {
 		return false
 	}
 
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		return false
-	}
-	if len(body) == 0 {
-		return false
+	if mock.MatchRequest.BodyMatch == BodyMatchPartial {
+		var reqJSON interface{}
+		var matchJSON interface{}
+		if json.Unmarshal(body, &reqJSON) != nil || json.Unmarshal([]byte(mockBody), &matchJSON) != nil {
+			return false
+		}
+		return containsJSON(reqJSON, matchJSON)
 	}
 
-	// replace body so it can be read again
-	req.Body = ioutil.NopCloser(bytes.NewReader(body))
-
 	// Perform exact string match
 	bodyStr := string(body)
 	if bodyStr == mockBody {
@@ -182,3 +345,47 @@ var matchesBody = func(req *http.Request, mock Mock) bool {
 
 	return false
 }
+
+var matchesBodyExpressions matcher = func(req *http.Request, mock Mock) bool {
+	if len(mock.MatchRequest.BodyExpressions) == 0 {
+		return true
+	}
+
+	body, ok := readBody(req)
+	if !ok {
+		return false
+	}
+
+	var reqJSON interface{}
+	if err := json.Unmarshal(body, &reqJSON); err != nil {
+		return false
+	}
+
+	for _, expr := range mock.MatchRequest.BodyExpressions {
+		e, err := parseBodyExpression(expr)
+		if err != nil || !e.Matches(reqJSON) {
+			return false
+		}
+	}
+	return true
+}
+
+// readBody reads the request body, replacing it so it can be read again. It's not ok if the body is empty
+func readBody(req *http.Request) ([]byte, bool) {
+	if req.Body == nil {
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, false
+	}
+	if len(body) == 0 {
+		return nil, false
+	}
+
+	// replace body so it can be read again
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, true
+}
@@ -25,7 +25,7 @@ func TestMatcher_Matches(t *testing.T) {
 		"matches with regex": {
 			mock:             mockPet,
 			request:          httptest.NewRequest(http.MethodPost, "/pet/search", strings.NewReader(`{"name": "Dave"}`)),
-			expectedResponse: Response{Body: `{"error":"No pet called 'Dave'"}`, Status: 400, Headers: map[string]string{"X-Correlation-ID": "1234567890"}},
+			expectedResponse: Response{Body: `{"error":"No pet called 'Dave'"}`, Status: 400, Headers: map[string]HeaderValues{"X-Correlation-ID": {"1234567890"}}},
 			expectedOK:       true,
 		},
 		"no match if path different": {
@@ -40,6 +40,52 @@ func TestMatcher_Matches(t *testing.T) {
 			expectedResponse: Response{},
 			expectedOK:       false,
 		},
+		"matches partial json body": {
+			mock: Mock{
+				MatchRequest: MatchRequest{
+					Method:    "POST",
+					Path:      "/basket",
+					Body:      `{"items": [{"sku": "456"}]}`,
+					BodyMatch: BodyMatchPartial,
+				},
+			},
+			request:    httptest.NewRequest(http.MethodPost, "/basket", strings.NewReader(basketJSON)),
+			expectedOK: true,
+		},
+		"no match if partial json body missing field": {
+			mock: Mock{
+				MatchRequest: MatchRequest{
+					Method:    "POST",
+					Path:      "/basket",
+					Body:      `{"items": [{"sku": "789"}]}`,
+					BodyMatch: BodyMatchPartial,
+				},
+			},
+			request:    httptest.NewRequest(http.MethodPost, "/basket", strings.NewReader(basketJSON)),
+			expectedOK: false,
+		},
+		"matches body expressions": {
+			mock: Mock{
+				MatchRequest: MatchRequest{
+					Method:          "POST",
+					Path:            "/basket",
+					BodyExpressions: []string{`$.items[0].sku == "123"`, `$.customer.email =~ "@example"`},
+				},
+			},
+			request:    httptest.NewRequest(http.MethodPost, "/basket", strings.NewReader(basketJSON)),
+			expectedOK: true,
+		},
+		"no match if any body expression fails": {
+			mock: Mock{
+				MatchRequest: MatchRequest{
+					Method:          "POST",
+					Path:            "/basket",
+					BodyExpressions: []string{`$.items[0].sku == "123"`, `$.customer.loyalty == false`},
+				},
+			},
+			request:    httptest.NewRequest(http.MethodPost, "/basket", strings.NewReader(basketJSON)),
+			expectedOK: false,
+		},
 		"with query params - page 1": {
 			mock: Mock{
 				MatchRequest: MatchRequest{
@@ -82,6 +128,104 @@ func TestMatcher_Matches(t *testing.T) {
 	}
 }
 
+func TestMatcher_MatchesScenarioState(t *testing.T) {
+	matcher := NewMatcher()
+	mock := Mock{
+		MatchRequest: MatchRequest{Path: "/basket"},
+		Scenario:     "basket",
+		State:        "has-items",
+	}
+	request := httptest.NewRequest(http.MethodGet, "/basket", nil)
+
+	assert.False(t, matcher.Match(request, mock))
+
+	matcher.Scenarios().Transition(Mock{Scenario: "basket", NewState: "has-items"})
+	assert.True(t, matcher.Match(request, mock))
+
+	matcher.Scenarios().Reset("basket")
+	assert.False(t, matcher.Match(request, mock))
+}
+
+func TestScenarios_TransitionChecksState(t *testing.T) {
+	scenarios := NewScenarios()
+	mock := Mock{Scenario: "basket", State: ScenarioStateStarted, NewState: "has-items"}
+
+	assert.True(t, scenarios.Transition(mock))
+	assert.Equal(t, "has-items", scenarios.State("basket"))
+
+	assert.False(t, scenarios.Transition(mock))
+	assert.Equal(t, "has-items", scenarios.State("basket"))
+
+	assert.True(t, scenarios.Transition(Mock{Scenario: "basket", NewState: ScenarioStateStarted}))
+	assert.Equal(t, ScenarioStateStarted, scenarios.State("basket"))
+	assert.Empty(t, scenarios.States())
+}
+
+func TestMatcher_MatchesHeadersAndCookies(t *testing.T) {
+	bearer := "^Bearer .+"
+	session := "abc"
+	mock := Mock{
+		MatchRequest: MatchRequest{
+			Path: "/basket",
+			Headers: map[string]*string{
+				"authorization": &bearer,
+				"X-Feature":     nil,
+			},
+			Cookies: map[string]*string{
+				"SESSION": &session,
+			},
+		},
+	}
+
+	tests := map[string]struct {
+		headers    map[string]string
+		cookies    map[string]string
+		expectedOK bool
+	}{
+		"matches": {
+			headers:    map[string]string{"Authorization": "Bearer 123"},
+			cookies:    map[string]string{"SESSION": "abc"},
+			expectedOK: true,
+		},
+		"no match if header regex different": {
+			headers:    map[string]string{"Authorization": "Basic 123"},
+			cookies:    map[string]string{"SESSION": "abc"},
+			expectedOK: false,
+		},
+		"no match if header missing": {
+			cookies:    map[string]string{"SESSION": "abc"},
+			expectedOK: false,
+		},
+		"no match if absent header present": {
+			headers:    map[string]string{"Authorization": "Bearer 123", "X-Feature": "new-checkout"},
+			cookies:    map[string]string{"SESSION": "abc"},
+			expectedOK: false,
+		},
+		"no match if cookie different": {
+			headers:    map[string]string{"Authorization": "Bearer 123"},
+			cookies:    map[string]string{"SESSION": "xyz"},
+			expectedOK: false,
+		},
+		"no match if cookie missing": {
+			headers:    map[string]string{"Authorization": "Bearer 123"},
+			expectedOK: false,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/basket", nil)
+			for k, v := range test.headers {
+				request.Header.Set(k, v)
+			}
+			for k, v := range test.cookies {
+				request.AddCookie(&http.Cookie{Name: k, Value: v})
+			}
+
+			assert.Equal(t, test.expectedOK, NewMatcher().Match(request, mock))
+		})
+	}
+}
+
 var mockUser = Mock{
 	MatchRequest: MatchRequest{
 		Method: "GET",
@@ -103,8 +247,26 @@ var mockPet = Mock{
 	Response: Response{
 		Status: 400,
 		Body:   `{"error":"No pet called 'Dave'"}`,
-		Headers: map[string]string{
-			"X-Correlation-ID": "1234567890",
+		Headers: map[string]HeaderValues{
+			"X-Correlation-ID": {"1234567890"},
 		},
 	},
 }
+
+func TestMatcher_MatchRequest_Host(t *testing.T) {
+	m := NewMatcher()
+	match := MatchRequest{Host: `^shop\.example\.com$`}
+
+	tests := map[string]bool{
+		"shop.example.com":      true,
+		"shop.example.com:8080": true,
+		"www.example.com":       false,
+	}
+	for host, expectedOK := range tests {
+		t.Run(host, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Host = host
+			assert.Equal(t, expectedOK, m.MatchRequest(r, match))
+		})
+	}
+}
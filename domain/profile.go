@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Profile switches the backends the proxy points at, e.g. to another environment
+type Profile struct {
+	// DefaultBackend replaces the default backend given on the command line. Optional
+	DefaultBackend *Backend `json:"default_backend,omitempty"`
+	// Backends replace the backends of proxy routes, by route name. Optional
+	Backends map[string]*Backend `json:"backends,omitempty"`
+}
+
+// WithProfile returns the config with the backends of the named profile. The config is returned
+// unchanged if name is empty
+func (c Config) WithProfile(name string) (Config, error) {
+	if name == "" {
+		return c, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return Config{}, fmt.Errorf("unknown profile '%s'", name)
+	}
+
+	if profile.DefaultBackend != nil {
+		c.DefaultBackend = profile.DefaultBackend
+	}
+	c.Routes = withBackends(c.Routes, profile.Backends)
+	servers := make([]Server, len(c.Servers))
+	for i, s := range c.Servers {
+		s.Routes = withBackends(s.Routes, profile.Backends)
+		servers[i] = s
+	}
+	c.Servers = servers
+	return c, nil
+}
+
+// withBackends returns a copy of routes, with the backends of proxy routes replaced by name
+func withBackends(routes []Route, backends map[string]*Backend) []Route {
+	replaced := make([]Route, len(routes))
+	for i, r := range routes {
+		if backend, ok := backends[r.Name]; ok && r.Name != "" && r.Type == RouteTypeProxy {
+			r.Backend = backend
+		}
+		replaced[i] = r
+	}
+	return replaced
+}
+
+func (c Config) profileProblems() Problems {
+	proxyRoutes := map[string]bool{}
+	for _, r := range c.Routes {
+		if r.Type == RouteTypeProxy {
+			proxyRoutes[r.Name] = true
+		}
+	}
+	for _, s := range c.Servers {
+		for _, r := range s.Routes {
+			if r.Type == RouteTypeProxy {
+				proxyRoutes[r.Name] = true
+			}
+		}
+	}
+
+	var problems Problems
+	for _, name := range sortedProfileNames(c.Profiles) {
+		profile := c.Profiles[name]
+		path := JoinPath("profiles", name)
+		if b := profile.DefaultBackend; b != nil && (b.URL == nil || b.Host == "") {
+			problems.add(JoinPath(path, "default_backend"), "default_backend must be an absolute URL")
+		}
+		problems = append(problems, profile.DefaultBackend.transportProblems(JoinPath(path, "default_backend"))...)
+		routeNames := make([]string, 0, len(profile.Backends))
+		for routeName := range profile.Backends {
+			routeNames = append(routeNames, routeName)
+		}
+		sort.Strings(routeNames)
+		for _, routeName := range routeNames {
+			backendPath := JoinPath(JoinPath(path, "backends"), routeName)
+			if b := profile.Backends[routeName]; b == nil || b.URL == nil || b.Host == "" {
+				problems.add(backendPath, "backend must be an absolute URL")
+			}
+			problems = append(problems, profile.Backends[routeName].transportProblems(backendPath)...)
+			if !proxyRoutes[routeName] {
+				problems.add(backendPath, "there's no proxy route named '%s'", routeName)
+			}
+		}
+	}
+	return problems
+}
+
+func sortedProfileNames(profiles map[string]Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_WithProfile(t *testing.T) {
+	backend := func(s string) *Backend {
+		u, _ := url.Parse(s)
+		return &Backend{URL: u}
+	}
+	pattern := &PathPattern{regexp.MustCompile("^/users/.*")}
+	conf := Config{
+		Routes: []Route{
+			{Name: "users", Type: RouteTypeProxy, PathPattern: pattern, Backend: backend("http://localhost:3000")},
+			{Name: "orders", Type: RouteTypeProxy, PathPattern: pattern, Backend: backend("http://localhost:3001")},
+		},
+		Servers: []Server{{Port: 8443, Routes: []Route{
+			{Name: "users", Type: RouteTypeProxy, PathPattern: pattern, Backend: backend("http://localhost:3000")},
+		}}},
+		Profiles: map[string]Profile{
+			"preprod": {
+				DefaultBackend: backend("https://preprod.example.com"),
+				Backends:       map[string]*Backend{"users": backend("https://users.preprod.example.com")},
+			},
+		},
+	}
+	require.NoError(t, conf.Validate())
+
+	preprod, err := conf.WithProfile("preprod")
+	require.NoError(t, err)
+	assert.Equal(t, "https://preprod.example.com", preprod.DefaultBackend.String())
+	assert.Equal(t, "https://users.preprod.example.com", preprod.Routes[0].Backend.String())
+	assert.Equal(t, "http://localhost:3001", preprod.Routes[1].Backend.String())
+	assert.Equal(t, "https://users.preprod.example.com", preprod.Servers[0].Routes[0].Backend.String())
+	// the original config is unchanged
+	assert.Equal(t, "http://localhost:3000", conf.Routes[0].Backend.String())
+	assert.Equal(t, "http://localhost:3000", conf.Servers[0].Routes[0].Backend.String())
+
+	_, err = conf.WithProfile("prod")
+	assert.EqualError(t, err, "unknown profile 'prod'")
+
+	conf.Profiles["test"] = Profile{Backends: map[string]*Backend{"basket": backend("https://basket.test.example.com")}}
+	assert.EqualError(t, conf.Validate(), "profiles.test.backends.basket: there's no proxy route named 'basket'")
+}
@@ -0,0 +1,94 @@
+package domain
+
+import "sync"
+
+// ScenarioStateStarted is the state every scenario is in until a mock moves it on
+const ScenarioStateStarted = "started"
+
+// Scenarios tracks the current state of each named scenario. Mocks belonging to a scenario only
+// match while it's in the mock's state, and can move the scenario to a new state when they respond
+type Scenarios struct {
+	mu     sync.Mutex
+	states map[string]string
+}
+
+func NewScenarios() *Scenarios {
+	return &Scenarios{states: map[string]string{}}
+}
+
+// State returns the current state of the named scenario
+func (s *Scenarios) State(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stateLocked(name)
+}
+
+func (s *Scenarios) stateLocked(name string) string {
+	if state, ok := s.states[name]; ok {
+		return state
+	}
+	return ScenarioStateStarted
+}
+
+// States returns the current state of every scenario that has left its started state
+func (s *Scenarios) States() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make(map[string]string, len(s.states))
+	for name, state := range s.states {
+		states[name] = state
+	}
+	return states
+}
+
+// SetState moves the named scenario to state
+func (s *Scenarios) SetState(name string, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state == ScenarioStateStarted {
+		delete(s.states, name)
+		return
+	}
+	s.states[name] = state
+}
+
+// Transition moves the mock's scenario to the mock's new state, if it has one. It checks the scenario
+// is still in the mock's state under the same lock, so only one of several concurrent requests matching
+// that state moves the scenario on, and returns false for the others
+func (s *Scenarios) Transition(mock Mock) bool {
+	if mock.Scenario == "" {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if mock.State != "" && s.stateLocked(mock.Scenario) != mock.State {
+		return false
+	}
+	if mock.NewState == "" {
+		return true
+	}
+	if mock.NewState == ScenarioStateStarted {
+		delete(s.states, mock.Scenario)
+		return true
+	}
+	s.states[mock.Scenario] = mock.NewState
+	return true
+}
+
+// Reset moves the named scenario back to its started state
+func (s *Scenarios) Reset(name string) {
+	s.SetState(name, ScenarioStateStarted)
+}
+
+// ResetAll moves every scenario back to its started state
+func (s *Scenarios) ResetAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states = map[string]string{}
+}
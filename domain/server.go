@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"net"
+	"strings"
+)
+
+// Server is a listener, or a virtual server sharing a listener's port with others, that has its own
+// default backend and routes
+type Server struct {
+	Name string `json:"name,omitempty"`
+	Port int    `json:"port"`
+	// Hosts are the host names the server answers to. A name starting with "*." matches any subdomain.
+	// If empty the server answers to every host on its port
+	Hosts []string `json:"hosts,omitempty"`
+	// DefaultBackend serves requests that don't match a route. Defaults to the proxy's default backend
+	DefaultBackend *Backend   `json:"default_backend,omitempty"`
+	TLS            *ServerTLS `json:"tls,omitempty"`
+	Routes         []Route    `json:"routes"`
+	// Origin is where the server was declared, if it was in an included file
+	Origin *Origin `json:"-"`
+}
+
+// ServerTLS is the certificate a server is served over HTTPS with
+type ServerTLS struct {
+	// Auto issues certificates from the proxy's local CA instead of using CertFile and KeyFile
+	Auto     bool   `json:"auto,omitempty"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// Validate checks the server has a port, and every route is valid
+func (s Server) Validate() error {
+	return s.problems("").err()
+}
+
+// MatchesHost checks if the server answers to host, which may include a port
+func (s Server) MatchesHost(host string) bool {
+	if len(s.Hosts) == 0 {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, name := range s.Hosts {
+		name = strings.ToLower(name)
+		if name == host || (strings.HasPrefix(name, "*.") && strings.HasSuffix(host, name[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// UsesAutoTLS checks if any server issues certificates from the local CA
+func (c Config) UsesAutoTLS() bool {
+	for _, s := range c.Servers {
+		if s.TLS != nil && s.TLS.Auto {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerFor returns the first server on port that answers to host, or nil if requests should be served
+// by the top level routes
+func (c Config) ServerFor(port int, host string) *Server {
+	for i, s := range c.Servers {
+		if s.Port == port && s.MatchesHost(host) {
+			return &c.Servers[i]
+		}
+	}
+	return nil
+}
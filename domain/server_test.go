@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_MatchesHost(t *testing.T) {
+	s := Server{Hosts: []string{"shop.example.com", "*.account.example.com"}}
+
+	tests := map[string]bool{
+		"shop.example.com":        true,
+		"SHOP.example.com:8443":   true,
+		"www.account.example.com": true,
+		"account.example.com":     false,
+		"www.shop.example.com":    false,
+	}
+	for host, expected := range tests {
+		t.Run(host, func(t *testing.T) {
+			assert.Equal(t, expected, s.MatchesHost(host))
+		})
+	}
+
+	assert.True(t, Server{}.MatchesHost("anything.example.com"))
+}
+
+func TestConfig_Validate_ServerTLS(t *testing.T) {
+	conf := Config{Servers: []Server{
+		{Port: 8443, TLS: &ServerTLS{CertFile: "shop.pem", KeyFile: "shop-key.pem"}},
+		{Port: 8443},
+	}}
+
+	assert.EqualError(t, conf.Validate(), "servers[1].tls: servers on port 8443 must all use tls, or none of them")
+}
@@ -0,0 +1,218 @@
+package domain
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+const randomStringChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// TemplateData is the request data available to templated mock responses
+type TemplateData struct {
+	Method string
+	Path   string
+	// PathGroups are the capture groups from matching MatchRequest.Path, with the whole match at 0
+	PathGroups []string
+	// PathParams are the named capture groups from matching MatchRequest.Path
+	PathParams map[string]string
+	Query      url.Values
+	Headers    http.Header
+	Cookies    map[string]string
+	// Body is the request body decoded from JSON, or nil if it isn't JSON
+	Body    interface{}
+	RawBody string
+}
+
+var templateFuncs = template.FuncMap{
+	"uuid":         newUUID,
+	"now":          time.Now,
+	"randomInt":    randomInt,
+	"randomString": randomString,
+	"json":         toJSON,
+}
+
+// Render executes the response body, header values and websocket messages as templates with data from the request,
+// if the response is templated. The mock's MatchRequest provides the path capture groups
+func (r Response) Render(req *http.Request, match MatchRequest) (Response, error) {
+	if !r.Template {
+		return r, nil
+	}
+
+	data, err := newTemplateData(req, match)
+	if err != nil {
+		return Response{}, err
+	}
+
+	rendered := r
+	// the pre-encoded bodies are of the template rather than the rendered body
+	rendered.EncodedBodies = nil
+	rendered.Body, err = renderTemplate("body", r.Body, data)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if r.Headers != nil {
+		rendered.Headers = make(map[string]HeaderValues, len(r.Headers))
+		for name, values := range r.Headers {
+			renderedValues := make(HeaderValues, len(values))
+			for i, value := range values {
+				renderedValues[i], err = renderTemplate(name, value, data)
+				if err != nil {
+					return Response{}, err
+				}
+			}
+			rendered.Headers[name] = renderedValues
+		}
+	}
+
+	if r.WebSocket != nil {
+		ws := *r.WebSocket
+		ws.Messages = make([]WebSocketMessage, len(r.WebSocket.Messages))
+		for i, m := range r.WebSocket.Messages {
+			ws.Messages[i] = m
+			ws.Messages[i].Body, err = renderTemplate("websocket", m.Body, data)
+			if err != nil {
+				return Response{}, err
+			}
+		}
+		rendered.WebSocket = &ws
+	}
+
+	return rendered, nil
+}
+
+// validateTemplate checks the response body and header values parse as templates
+func (r Response) validateTemplate() error {
+	if !r.Template {
+		return nil
+	}
+	if _, err := parseTemplate("body", r.Body); err != nil {
+		return err
+	}
+	for name, values := range r.Headers {
+		for _, value := range values {
+			if _, err := parseTemplate(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	if r.WebSocket != nil {
+		for _, m := range r.WebSocket.Messages {
+			if _, err := parseTemplate("websocket", m.Body); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func newTemplateData(req *http.Request, match MatchRequest) (TemplateData, error) {
+	data := TemplateData{
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		PathGroups: []string{req.URL.Path},
+		PathParams: map[string]string{},
+		Query:      req.URL.Query(),
+		Headers:    req.Header,
+		Cookies:    map[string]string{},
+	}
+
+	if re, err := regexp.Compile(match.Path); err == nil {
+		if groups := re.FindStringSubmatch(req.URL.Path); groups != nil {
+			data.PathGroups = groups
+			for i, name := range re.SubexpNames() {
+				if name != "" {
+					data.PathParams[name] = groups[i]
+				}
+			}
+		}
+	}
+
+	for _, c := range req.Cookies() {
+		data.Cookies[c.Name] = c.Value
+	}
+
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return TemplateData{}, err
+		}
+		// replace body so it can be read again
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		data.RawBody = string(body)
+		var v interface{}
+		if json.Unmarshal(body, &v) == nil {
+			data.Body = v
+		}
+	}
+
+	return data, nil
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+func renderTemplate(name string, text string, data TemplateData) (string, error) {
+	t, err := parseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// randomInt returns a random integer in the range [min, max]
+func randomInt(min int, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randomInt max %d is less than min %d", max, min)
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max-min+1)))
+	if err != nil {
+		return 0, err
+	}
+	return min + int(n.Int64()), nil
+}
+
+// randomString returns a random alphanumeric string of length n
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	for i := range b {
+		c, err := rand.Int(rand.Reader, big.NewInt(int64(len(randomStringChars))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = randomStringChars[c.Int64()]
+	}
+	return string(b), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
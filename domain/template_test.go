@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponse_Render(t *testing.T) {
+	tests := map[string]struct {
+		body     string
+		request  *http.Request
+		expected string
+	}{
+		"path group": {
+			body:     `{"id": "{{index .PathGroups 2}}"}`,
+			request:  httptest.NewRequest(http.MethodGet, "/api/v1/product/123", nil),
+			expected: `{"id": "123"}`,
+		},
+		"named path group": {
+			body:     `{"category": "{{.PathParams.category}}"}`,
+			request:  httptest.NewRequest(http.MethodGet, "/api/v1/category/fruit/product/1", nil),
+			expected: `{"category": "fruit"}`,
+		},
+		"query": {
+			body:     `{{.Query.Get "include"}}`,
+			request:  httptest.NewRequest(http.MethodGet, "/api/v1/product/1?include=price", nil),
+			expected: `price`,
+		},
+		"header": {
+			body: `{{.Headers.Get "Accept"}}`,
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/api/v1/product/1", nil)
+				r.Header.Set("Accept", "text/plain")
+				return r
+			}(),
+			expected: `text/plain`,
+		},
+		"cookie": {
+			body: `{{.Cookies.SESSION}}`,
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/api/v1/product/1", nil)
+				r.AddCookie(&http.Cookie{Name: "SESSION", Value: "abc"})
+				return r
+			}(),
+			expected: `abc`,
+		},
+		"json body field": {
+			body:     `{"sku": {{json .Body.items}}}`,
+			request:  httptest.NewRequest(http.MethodPost, "/api/v1/product/1", strings.NewReader(`{"items": ["1", "2"]}`)),
+			expected: `{"sku": ["1","2"]}`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			response := Response{Status: 200, Body: test.body, Template: true}
+			match := MatchRequest{Path: `^/api/v1/(?:category/(?P<category>\w+)/)?product/(\d+)`}
+
+			rendered, err := response.Render(test.request, match)
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, rendered.Body)
+		})
+	}
+}
+
+func TestResponse_Render_Helpers(t *testing.T) {
+	response := Response{
+		Status:   200,
+		Body:     `{{uuid}} {{randomInt 5 5}} {{len (randomString 8)}} {{now.Year}}`,
+		Headers:  map[string]HeaderValues{"X-Request-Id": {"{{uuid}}"}},
+		Template: true,
+	}
+
+	rendered, err := response.Render(httptest.NewRequest(http.MethodGet, "/", nil), MatchRequest{})
+
+	assert.NoError(t, err)
+	uuid := `[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}`
+	assert.Regexp(t, regexp.MustCompile(`^`+uuid+` 5 8 \d{4}$`), rendered.Body)
+	assert.Regexp(t, regexp.MustCompile(`^`+uuid+`$`), rendered.Headers["X-Request-Id"][0])
+}
+
+func TestResponse_Render_NotTemplate(t *testing.T) {
+	response := Response{Status: 200, Body: `{{index .PathGroups 1}}`}
+
+	rendered, err := response.Render(httptest.NewRequest(http.MethodGet, "/", nil), MatchRequest{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{{index .PathGroups 1}}`, rendered.Body)
+}
@@ -0,0 +1,133 @@
+package domain
+
+import (
+	"net/url"
+)
+
+// Transport configures the connections requests are sent to backends over. Unset fields use Go's defaults
+type Transport struct {
+	// DialTimeout limits how long connecting to the backend takes
+	DialTimeout *Duration `json:"dial_timeout,omitempty"`
+	// TLSHandshakeTimeout limits how long the TLS handshake with the backend takes
+	TLSHandshakeTimeout *Duration `json:"tls_handshake_timeout,omitempty"`
+	// ResponseHeaderTimeout limits how long to wait for the backend's response headers after sending the request
+	ResponseHeaderTimeout *Duration `json:"response_header_timeout,omitempty"`
+	// IdleConnTimeout is how long idle connections are kept open for reuse
+	IdleConnTimeout     *Duration `json:"idle_conn_timeout,omitempty"`
+	MaxIdleConns        int       `json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int       `json:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost     int       `json:"max_conns_per_host,omitempty"`
+	// Proxy is the URL of an HTTP proxy to send requests through. Defaults to the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables
+	Proxy string `json:"proxy,omitempty"`
+	// CAFile is a PEM bundle of CA certificates to trust, as well as the system's
+	CAFile string `json:"ca_file,omitempty"`
+	// CertFile and KeyFile are a client certificate to present to backends that require one
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// InsecureSkipVerify accepts any certificate the backend presents, e.g. a self-signed one in a test environment.
+	// A route can set it to false to verify its backend when the top level transport doesn't
+	InsecureSkipVerify *bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// With returns the transport settings, with any that are set in override replacing them
+func (t *Transport) With(override *Transport) *Transport {
+	if t == nil {
+		return override
+	}
+	if override == nil {
+		return t
+	}
+
+	merged := *t
+	for _, d := range []struct {
+		to   **Duration
+		from *Duration
+	}{
+		{&merged.DialTimeout, override.DialTimeout},
+		{&merged.TLSHandshakeTimeout, override.TLSHandshakeTimeout},
+		{&merged.ResponseHeaderTimeout, override.ResponseHeaderTimeout},
+		{&merged.IdleConnTimeout, override.IdleConnTimeout},
+	} {
+		if d.from != nil {
+			*d.to = d.from
+		}
+	}
+	for _, n := range []struct {
+		to   *int
+		from int
+	}{
+		{&merged.MaxIdleConns, override.MaxIdleConns},
+		{&merged.MaxIdleConnsPerHost, override.MaxIdleConnsPerHost},
+		{&merged.MaxConnsPerHost, override.MaxConnsPerHost},
+	} {
+		if n.from != 0 {
+			*n.to = n.from
+		}
+	}
+	for _, s := range []struct {
+		to   *string
+		from string
+	}{
+		{&merged.Proxy, override.Proxy},
+		{&merged.CAFile, override.CAFile},
+		{&merged.CertFile, override.CertFile},
+		{&merged.KeyFile, override.KeyFile},
+	} {
+		if s.from != "" {
+			*s.to = s.from
+		}
+	}
+	if override.InsecureSkipVerify != nil {
+		merged.InsecureSkipVerify = override.InsecureSkipVerify
+	}
+	return &merged
+}
+
+func (t Transport) problems(path string) Problems {
+	var problems Problems
+	durations := []struct {
+		name  string
+		value *Duration
+	}{
+		{"dial_timeout", t.DialTimeout},
+		{"tls_handshake_timeout", t.TLSHandshakeTimeout},
+		{"response_header_timeout", t.ResponseHeaderTimeout},
+		{"idle_conn_timeout", t.IdleConnTimeout},
+	}
+	for _, d := range durations {
+		if d.value.Value() < 0 {
+			problems.add(JoinPath(path, d.name), "%s must not be negative", d.name)
+		}
+	}
+	sizes := []struct {
+		name  string
+		value int
+	}{
+		{"max_idle_conns", t.MaxIdleConns},
+		{"max_idle_conns_per_host", t.MaxIdleConnsPerHost},
+		{"max_conns_per_host", t.MaxConnsPerHost},
+	}
+	for _, s := range sizes {
+		if s.value < 0 {
+			problems.add(JoinPath(path, s.name), "%s must not be negative", s.name)
+		}
+	}
+	if t.Proxy != "" {
+		if u, err := url.Parse(t.Proxy); err != nil || u.Host == "" {
+			problems.add(JoinPath(path, "proxy"), "proxy must be an absolute URL")
+		}
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		problems.add(path, "cert_file and key_file must be set together")
+	}
+	return problems
+}
+
+// transportProblems checks the backend's transport settings, if it has any
+func (b *Backend) transportProblems(path string) Problems {
+	if b == nil || b.Transport == nil {
+		return nil
+	}
+	return b.Transport.problems(JoinPath(path, "transport"))
+}
@@ -0,0 +1,66 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackend_UnmarshalJSON(t *testing.T) {
+	var route Route
+	require.NoError(t, json.Unmarshal([]byte(`{"backend": "http://localhost:3000"}`), &route))
+	assert.Equal(t, "http://localhost:3000", route.Backend.String())
+	assert.Nil(t, route.Backend.Transport)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"backend": {
+		"url": "https://api.example.com",
+		"transport": {"dial_timeout": "2s", "ca_file": "ca.pem", "max_conns_per_host": 4}
+	}}`), &route))
+	assert.Equal(t, "https://api.example.com", route.Backend.String())
+	assert.Equal(t, &Transport{DialTimeout: &Duration{2 * time.Second}, CAFile: "ca.pem", MaxConnsPerHost: 4}, route.Backend.Transport)
+
+	b, err := json.Marshal(route.Backend)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"url": "https://api.example.com", "transport": {"dial_timeout": "2s", "ca_file": "ca.pem", "max_conns_per_host": 4}}`, string(b))
+}
+
+func TestTransport_With(t *testing.T) {
+	insecure, secure := true, false
+	global := &Transport{DialTimeout: &Duration{time.Second}, Proxy: "http://proxy.example.com:3128", MaxIdleConns: 10}
+	backend := &Transport{DialTimeout: &Duration{5 * time.Second}, InsecureSkipVerify: &insecure}
+
+	assert.Equal(t, &Transport{
+		DialTimeout:        &Duration{5 * time.Second},
+		Proxy:              "http://proxy.example.com:3128",
+		MaxIdleConns:       10,
+		InsecureSkipVerify: &insecure,
+	}, global.With(backend))
+	// a backend can verify certificates when the global settings don't
+	verified := (&Transport{InsecureSkipVerify: &insecure}).With(&Transport{InsecureSkipVerify: &secure})
+	assert.False(t, *verified.InsecureSkipVerify)
+	assert.Equal(t, backend, (*Transport)(nil).With(backend))
+	assert.Equal(t, global, global.With(nil))
+	// the global settings are unchanged
+	assert.Equal(t, time.Second, global.DialTimeout.Value())
+}
+
+func TestTransport_Problems(t *testing.T) {
+	var conf Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"transport": {"dial_timeout": "-1s", "proxy": "proxy.example.com"},
+		"routes": [{"type": "proxy", "path_pattern": "^/api/.*", "backend": {
+			"url": "https://api.example.com",
+			"transport": {"cert_file": "client.pem", "max_idle_conns": -1}
+		}}]
+	}`), &conf))
+
+	assert.Equal(t, Problems{
+		{Path: "transport.dial_timeout", Message: "dial_timeout must not be negative"},
+		{Path: "transport.proxy", Message: "proxy must be an absolute URL"},
+		{Path: "routes[0].backend.transport.max_idle_conns", Message: "max_idle_conns must not be negative"},
+		{Path: "routes[0].backend.transport", Message: "cert_file and key_file must be set together"},
+	}, conf.Problems())
+}
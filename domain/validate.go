@@ -0,0 +1,359 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Problem is something wrong with the config, at a JSON path in it such as routes[2].backend
+type Problem struct {
+	// File is the included file the problem is in, or empty for the top level config file
+	File    string
+	Path    string
+	Message string
+	// Warning is set for problems that don't stop the config being used, like unreachable routes
+	Warning bool
+}
+
+func (p Problem) Error() string {
+	msg := p.Message
+	if p.Path != "" {
+		msg = p.Path + ": " + msg
+	}
+	if p.File != "" {
+		msg = p.File + ": " + msg
+	}
+	return msg
+}
+
+// Problems are every problem found in a config, one per line when used as an error
+type Problems []Problem
+
+func (p Problems) Error() string {
+	lines := make([]string, len(p))
+	for i, problem := range p {
+		lines[i] = problem.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Errors returns the problems that stop the config being used
+func (p Problems) Errors() Problems {
+	var errs Problems
+	for _, problem := range p {
+		if !problem.Warning {
+			errs = append(errs, problem)
+		}
+	}
+	return errs
+}
+
+// Warnings returns the problems that don't stop the config being used
+func (p Problems) Warnings() Problems {
+	var warnings Problems
+	for _, problem := range p {
+		if problem.Warning {
+			warnings = append(warnings, problem)
+		}
+	}
+	return warnings
+}
+
+// err returns the errors as an error, or nil if there aren't any
+func (p Problems) err() error {
+	if errs := p.Errors(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *Problems) add(path string, format string, args ...interface{}) {
+	*p = append(*p, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *Problems) warn(path string, format string, args ...interface{}) {
+	*p = append(*p, Problem{Path: path, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+// addErr adds err, unless it's nil
+func (p *Problems) addErr(path string, err error) {
+	if err != nil {
+		p.add(path, "%s", err.Error())
+	}
+}
+
+// InFile sets the file of every problem that doesn't already have one
+func (p Problems) InFile(file string) Problems {
+	for i := range p {
+		if p[i].File == "" {
+			p[i].File = file
+		}
+	}
+	return p
+}
+
+// JoinPath appends a field name or [index] to a JSON path
+func JoinPath(path string, name string) string {
+	if path == "" || strings.HasPrefix(name, "[") {
+		return path + name
+	}
+	return path + "." + name
+}
+
+// Problems checks the whole config, returning every problem found
+func (c Config) Problems() Problems {
+	var problems Problems
+	if c.Chaos != nil {
+		problems.addErr("chaos", c.Chaos.Validate())
+	}
+	if c.CORS != nil {
+		problems = append(problems, c.CORS.problems("cors")...)
+	}
+	if c.Transport != nil {
+		problems = append(problems, c.Transport.problems("transport")...)
+	}
+	problems = append(problems, routesProblems(c.Routes, "routes")...)
+
+	tlsPorts := map[int]bool{}
+	for i, s := range c.Servers {
+		path, file := fmt.Sprintf("servers[%d]", i), ""
+		if s.Origin != nil {
+			path, file = s.Origin.Path, s.Origin.File
+		}
+		serverProblems := s.problems(path)
+		if tls, ok := tlsPorts[s.Port]; ok && tls != (s.TLS != nil) {
+			serverProblems.add(JoinPath(path, "tls"), "servers on port %d must all use tls, or none of them", s.Port)
+		}
+		tlsPorts[s.Port] = s.TLS != nil
+		problems = append(problems, serverProblems.InFile(file)...)
+	}
+	return append(problems, c.profileProblems()...)
+}
+
+func (s Server) problems(path string) Problems {
+	var problems Problems
+	if s.Port <= 0 || s.Port > 65535 {
+		problems.add(JoinPath(path, "port"), "invalid port %d", s.Port)
+	}
+	if s.DefaultBackend != nil && (s.DefaultBackend.URL == nil || s.DefaultBackend.Host == "") {
+		problems.add(JoinPath(path, "default_backend"), "default_backend must be an absolute URL")
+	}
+	problems = append(problems, s.DefaultBackend.transportProblems(JoinPath(path, "default_backend"))...)
+	if s.TLS != nil && !s.TLS.Auto && (s.TLS.CertFile == "" || s.TLS.KeyFile == "") {
+		problems.add(JoinPath(path, "tls"), "tls requires auto, or both cert_file and key_file")
+	}
+	return append(problems, routesProblems(s.Routes, JoinPath(path, "routes"))...)
+}
+
+// routesProblems checks each route, and warns about routes that can never match because of an earlier one
+func routesProblems(routes []Route, path string) Problems {
+	var problems Problems
+	for i, r := range routes {
+		routePath, file := JoinPath(path, fmt.Sprintf("[%d]", i)), ""
+		if r.Origin != nil {
+			routePath, file = r.Origin.Path, r.Origin.File
+		}
+		routeProblems := r.problems(routePath)
+		for j, earlier := range routes[:i] {
+			if reason := shadows(earlier, r); reason != "" {
+				routeProblems.warn(routePath, "unreachable, as %s %s", describeRoute(j, earlier), reason)
+				break
+			}
+		}
+		problems = append(problems, routeProblems.InFile(file)...)
+	}
+	return problems
+}
+
+// describeRoute names a route by its index, or where it was declared if it was in an included file
+func describeRoute(i int, r Route) string {
+	if r.Origin != nil {
+		return fmt.Sprintf("%s %s", r.Origin.File, r.Origin.Path)
+	}
+	return fmt.Sprintf("route %d", i)
+}
+
+func (r Route) problems(path string) Problems {
+	var problems Problems
+	switch r.Type {
+	case RouteTypeProxy, RouteTypeRedirect:
+		if r.PathPattern == nil || r.PathPattern.Regexp == nil {
+			problems.add(JoinPath(path, "path_pattern"), "missing path_pattern on %s type route", r.Type)
+		}
+		if r.Type == RouteTypeProxy {
+			if r.Backend == nil || r.Backend.URL == nil {
+				problems.add(JoinPath(path, "backend"), "missing backend on proxy type route")
+			} else if r.Backend.Host == "" {
+				problems.add(JoinPath(path, "backend"), "backend must be an absolute URL")
+			}
+			problems = append(problems, r.Backend.transportProblems(JoinPath(path, "backend"))...)
+		}
+		if r.Type == RouteTypeRedirect && r.Redirect == nil {
+			problems.add(JoinPath(path, "redirect"), "missing redirect config on redirect type route")
+		}
+		if r.Redirect != nil {
+			redirectType := r.Redirect.Type
+			if redirectType != "permanent" && redirectType != "temporary" {
+				problems.add(JoinPath(path, "redirect.type"), "invalid redirect type '%s'", redirectType)
+			}
+		}
+		for i, rule := range r.Rewrite {
+			if rule.PathPattern == nil || rule.PathPattern.Regexp == nil {
+				problems.add(JoinPath(path, fmt.Sprintf("rewrite[%d].path_pattern", i)), "missing path_pattern on rewrite rule")
+			}
+		}
+	case RouteTypeMock:
+		if r.Mock == nil {
+			problems.add(JoinPath(path, "mock"), "missing mock config on mock type route")
+			break
+		}
+		mockPath := JoinPath(path, "mock")
+		if r.Mock.Scenario == "" && (r.Mock.State != "" || r.Mock.NewState != "") {
+			problems.add(JoinPath(mockPath, "scenario"), "mock state requires a scenario")
+		}
+		problems = append(problems, r.Mock.MatchRequest.problems(JoinPath(mockPath, "request"))...)
+		if err := r.Mock.Response.validateTemplate(); err != nil {
+			problems.add(JoinPath(mockPath, "response"), "invalid mock response template: %v", err)
+		}
+		if r.Mock.Response.WebSocket != nil {
+			problems.addErr(JoinPath(mockPath, "response.websocket"), r.Mock.Response.WebSocket.Validate())
+		}
+	default:
+		problems.add(JoinPath(path, "type"), "unknown route type '%s'", r.Type)
+	}
+	if r.Cookies != nil {
+		problems = append(problems, r.Cookies.problems(JoinPath(path, "cookies"))...)
+		if r.Type != RouteTypeProxy {
+			problems.warn(JoinPath(path, "cookies"), "cookies are only rewritten by proxy type routes")
+		}
+	}
+	if r.CORS != nil {
+		problems = append(problems, r.CORS.problems(JoinPath(path, "cors"))...)
+	}
+	if r.Auth != nil {
+		problems = append(problems, r.Auth.problems(JoinPath(path, "auth"))...)
+		if r.Type != RouteTypeProxy {
+			problems.warn(JoinPath(path, "auth"), "auth is only used by proxy type routes")
+		}
+	}
+	if r.Match != nil {
+		problems = append(problems, r.Match.problems(JoinPath(path, "match"))...)
+	}
+	if r.Chaos != nil {
+		problems.addErr(JoinPath(path, "chaos"), r.Chaos.Validate())
+	}
+	return problems
+}
+
+func (m MatchRequest) problems(path string) Problems {
+	var problems Problems
+	if m.Host != "" {
+		problems.addErr(JoinPath(path, "host"), validateRegex(m.Host))
+	}
+	if m.Path != "" {
+		problems.addErr(JoinPath(path, "path"), validateRegex(m.Path))
+	}
+	if m.Query != "" {
+		query, err := url.ParseQuery(m.Query)
+		if err != nil {
+			problems.add(JoinPath(path, "query"), "invalid query: %v", err)
+		}
+		keys := make([]string, 0, len(query))
+		for key := range query {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, value := range query[key] {
+				problems.addErr(JoinPath(path, "query"), validateRegex(value))
+			}
+		}
+	}
+	problems = append(problems, valuePatternProblems(JoinPath(path, "headers"), m.Headers)...)
+	problems = append(problems, valuePatternProblems(JoinPath(path, "cookies"), m.Cookies)...)
+	switch m.BodyMatch {
+	case "", BodyMatchExact, BodyMatchPartial:
+	default:
+		problems.add(JoinPath(path, "body_match"), "invalid body match '%s'", m.BodyMatch)
+	}
+	for i, expr := range m.BodyExpressions {
+		if _, err := parseBodyExpression(expr); err != nil {
+			problems.addErr(JoinPath(path, fmt.Sprintf("body_expressions[%d]", i)), err)
+		}
+	}
+	return problems
+}
+
+// valuePatternProblems checks the header or cookie patterns are valid regexes, in name order
+func valuePatternProblems(path string, patterns map[string]*string) Problems {
+	names := make([]string, 0, len(patterns))
+	for name := range patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems Problems
+	for _, name := range names {
+		if pattern := patterns[name]; pattern != nil {
+			problems.addErr(JoinPath(path, name), validateRegex(*pattern))
+		}
+	}
+	return problems
+}
+
+func validateRegex(pattern string) error {
+	_, err := regexp.Compile(pattern)
+	return err
+}
+
+// shadows returns why earlier matches every request that later would, or "" if it doesn't, or it can't tell
+func shadows(earlier Route, later Route) string {
+	if earlier.Match != nil {
+		return ""
+	}
+
+	switch earlier.Type {
+	case RouteTypeProxy, RouteTypeRedirect:
+		if earlier.PathPattern == nil || earlier.PathPattern.Regexp == nil {
+			return ""
+		}
+		if matchesEveryPath(earlier.PathPattern.String()) {
+			return fmt.Sprintf("has path_pattern '%s' which matches every path", earlier.PathPattern.String())
+		}
+		var laterPattern string
+		switch later.Type {
+		case RouteTypeProxy, RouteTypeRedirect:
+			if later.PathPattern != nil && later.PathPattern.Regexp != nil {
+				laterPattern = later.PathPattern.String()
+			}
+		case RouteTypeMock:
+			if later.Mock != nil {
+				laterPattern = later.Mock.MatchRequest.Path
+			}
+		}
+		if laterPattern != "" && laterPattern == earlier.PathPattern.String() {
+			return fmt.Sprintf("has the same path_pattern '%s'", laterPattern)
+		}
+	case RouteTypeMock:
+		if earlier.Mock == nil || earlier.Mock.State != "" || later.Type != RouteTypeMock || later.Mock == nil {
+			return ""
+		}
+		if reflect.DeepEqual(earlier.Mock.MatchRequest, later.Mock.MatchRequest) {
+			return "has the same mock request"
+		}
+	}
+	return ""
+}
+
+// matchesEveryPath checks for the common ways of writing a pattern that matches any path
+func matchesEveryPath(pattern string) bool {
+	switch strings.TrimPrefix(pattern, "^") {
+	case "", ".*", ".*$", "/", "/.*", "/.*$":
+		return true
+	}
+	return false
+}
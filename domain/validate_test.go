@@ -0,0 +1,130 @@
+package domain
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Problems(t *testing.T) {
+	backend, _ := url.Parse("http://localhost:3000")
+	conf := Config{
+		Routes: []Route{
+			{Type: RouteTypeProxy, Backend: &Backend{URL: backend}},
+			{Type: RouteTypeRedirect, PathPattern: &PathPattern{regexp.MustCompile("^/old/.*")}, Redirect: &Redirect{To: "/new", Type: "sometimes"}},
+			{Type: RouteTypeMock, Mock: &Mock{MatchRequest: MatchRequest{Path: "^/api/(", Query: "page=[0-9"}}},
+			{Type: "proxied"},
+		},
+		Servers: []Server{{Port: 8443, Routes: []Route{{Type: RouteTypeProxy, PathPattern: &PathPattern{regexp.MustCompile("^/")}}}}},
+	}
+
+	assert.Equal(t, Problems{
+		{Path: "routes[0].path_pattern", Message: "missing path_pattern on proxy type route"},
+		{Path: "routes[1].redirect.type", Message: "invalid redirect type 'sometimes'"},
+		{Path: "routes[2].mock.request.path", Message: "error parsing regexp: missing closing ): `^/api/(`"},
+		{Path: "routes[2].mock.request.query", Message: "error parsing regexp: missing closing ]: `[0-9`"},
+		{Path: "routes[3].type", Message: "unknown route type 'proxied'"},
+		{Path: "servers[0].routes[0].backend", Message: "missing backend on proxy type route"},
+	}, conf.Problems())
+}
+
+func TestConfig_Problems_Unreachable(t *testing.T) {
+	backend, _ := url.Parse("http://localhost:3000")
+	proxyRoute := func(pattern string) Route {
+		return Route{Type: RouteTypeProxy, PathPattern: &PathPattern{regexp.MustCompile(pattern)}, Backend: &Backend{URL: backend}}
+	}
+	mockRoute := func(path string, state string) Route {
+		return Route{Type: RouteTypeMock, Mock: &Mock{Scenario: "s", State: state, MatchRequest: MatchRequest{Path: path}}}
+	}
+	narrowed := proxyRoute("^/.*")
+	narrowed.Match = &MatchRequest{Method: "POST"}
+
+	conf := Config{Routes: []Route{
+		mockRoute("^/orders$", "pending"),
+		mockRoute("^/orders$", ""),
+		mockRoute("^/orders$", "done"),
+		proxyRoute("^/api/.*"),
+		mockRoute("^/api/.*", ""),
+		narrowed,
+		proxyRoute("^/"),
+		proxyRoute("^/other"),
+	}}
+
+	problems := conf.Problems()
+	assert.Empty(t, problems.Errors())
+	assert.Equal(t, Problems{
+		{Path: "routes[2]", Message: "unreachable, as route 1 has the same mock request", Warning: true},
+		{Path: "routes[4]", Message: "unreachable, as route 3 has the same path_pattern '^/api/.*'", Warning: true},
+		{Path: "routes[7]", Message: "unreachable, as route 6 has path_pattern '^/' which matches every path", Warning: true},
+	}, problems.Warnings())
+	assert.NoError(t, conf.Validate())
+}
+
+func TestConfig_Problems_HeadersAndCookies(t *testing.T) {
+	backend, _ := url.Parse("http://localhost:3000")
+	valid, invalid := "^application/json", "^(text"
+	match := MatchRequest{
+		Headers: map[string]*string{"X-Version": &invalid, "Accept": &valid, "X-Absent": nil},
+		Cookies: map[string]*string{"session": &invalid},
+	}
+	conf := Config{Routes: []Route{
+		{Type: RouteTypeMock, Mock: &Mock{MatchRequest: match}},
+		{Type: RouteTypeProxy, PathPattern: &PathPattern{regexp.MustCompile("^/api/.*")}, Backend: &Backend{URL: backend}, Match: &match},
+	}}
+
+	assert.Equal(t, Problems{
+		{Path: "routes[0].mock.request.headers.X-Version", Message: "error parsing regexp: missing closing ): `^(text`"},
+		{Path: "routes[0].mock.request.cookies.session", Message: "error parsing regexp: missing closing ): `^(text`"},
+		{Path: "routes[1].match.headers.X-Version", Message: "error parsing regexp: missing closing ): `^(text`"},
+		{Path: "routes[1].match.cookies.session", Message: "error parsing regexp: missing closing ): `^(text`"},
+	}, conf.Problems())
+}
+
+func TestConfig_Problems_Auth(t *testing.T) {
+	backend, _ := url.Parse("http://localhost:3000")
+	pattern := &PathPattern{regexp.MustCompile("^/api/.*")}
+	proxyRoute := func(auth *Auth) Route {
+		return Route{Type: RouteTypeProxy, PathPattern: pattern, Backend: &Backend{URL: backend}, Auth: auth}
+	}
+	conf := Config{Routes: []Route{
+		proxyRoute(&Auth{Type: AuthTypeBasic, Username: "user"}),
+		proxyRoute(&Auth{Type: AuthTypeBasic}),
+		proxyRoute(&Auth{Type: AuthTypeBearer, Token: "token", TokenEnv: "TOKEN"}),
+		proxyRoute(&Auth{Type: AuthTypeOAuth2, TokenURL: "/token"}),
+		proxyRoute(&Auth{Type: "digest"}),
+		{Type: RouteTypeRedirect, PathPattern: &PathPattern{regexp.MustCompile("^/old$")}, Redirect: &Redirect{To: "/new", Type: "permanent"}, Auth: &Auth{Type: AuthTypeBearer, Token: "token"}},
+	}}
+
+	problems := conf.Problems()
+	assert.Equal(t, Problems{
+		{Path: "routes[1].auth.username", Message: "basic auth requires a username"},
+		{Path: "routes[2].auth", Message: "bearer auth requires one of token, token_file or token_env"},
+		{Path: "routes[3].auth.token_url", Message: "oauth2 auth requires an absolute token_url"},
+		{Path: "routes[3].auth.client_id", Message: "oauth2 auth requires a client_id"},
+		{Path: "routes[4].auth.type", Message: "unknown auth type 'digest'"},
+	}, problems.Errors())
+	assert.Contains(t, problems.Warnings(), Problem{Path: "routes[5].auth", Message: "auth is only used by proxy type routes", Warning: true})
+}
+
+func TestConfig_Problems_Cookies(t *testing.T) {
+	backend, _ := url.Parse("http://localhost:3000")
+	none, strictest := "None", "strictest"
+	insecure := false
+	conf := Config{Routes: []Route{
+		{Type: RouteTypeProxy, PathPattern: &PathPattern{regexp.MustCompile("^/api/.*")}, Backend: &Backend{URL: backend}, Cookies: &Cookies{
+			SameSite: &none,
+			Secure:   &insecure,
+			Rename:   map[string]string{"JSESSIONID": "session", "SESSION": "session", "id": "bad name"},
+		}},
+		{Type: RouteTypeProxy, PathPattern: &PathPattern{regexp.MustCompile("^/account/.*")}, Backend: &Backend{URL: backend}, Cookies: &Cookies{SameSite: &strictest}},
+	}}
+
+	assert.Equal(t, Problems{
+		{Path: "routes[0].cookies", Message: "browsers reject SameSite=None cookies that aren't Secure", Warning: true},
+		{Path: "routes[0].cookies.rename.SESSION", Message: "cookies 'JSESSIONID' and 'SESSION' are both renamed to 'session'"},
+		{Path: "routes[0].cookies.rename.id", Message: "invalid cookie name"},
+		{Path: "routes[1].cookies.same_site", Message: "invalid same_site 'strictest'"},
+	}, conf.Problems())
+}
@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// WebSocket is a sequence of messages a mock sends after upgrading the request to a websocket
+type WebSocket struct {
+	Messages []WebSocketMessage `json:"messages"`
+	// Close closes the websocket after the last message, rather than waiting for the client to close it
+	Close bool `json:"close,omitempty"`
+}
+
+// WebSocketMessage is a message sent to the client by a websocket mock
+type WebSocketMessage struct {
+	// WaitFor waits for a message from the client matching this regex before sending
+	WaitFor string `json:"wait_for,omitempty"`
+	// Delay waits for a duration before sending
+	Delay *Duration `json:"delay,omitempty"`
+	Body  string    `json:"body"`
+	// Binary sends the body as a binary message rather than text
+	Binary bool `json:"binary,omitempty"`
+}
+
+// Validate checks the wait_for regexes compile
+func (ws WebSocket) Validate() error {
+	for i, m := range ws.Messages {
+		if m.WaitFor == "" {
+			continue
+		}
+		if _, err := regexp.Compile(m.WaitFor); err != nil {
+			return fmt.Errorf("invalid websocket message %d wait_for: %w", i, err)
+		}
+	}
+	return nil
+}
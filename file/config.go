@@ -1,75 +1,430 @@
 package file
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
 )
 
+// ConfigProvider loads JSON or YAML config, and any files it includes
 func ConfigProvider() domain.ConfigProvider {
 	return func(path string) (domain.Config, error) {
-		f, err := os.Open(path)
+		l := &loader{rootDir: filepath.Dir(path), loaded: map[string]bool{}}
+		c, err := l.load(path, "")
 		if err != nil {
 			return domain.Config{}, err
 		}
+		c.Files = l.files
 
-		var c domain.Config
-		err = json.NewDecoder(f).Decode(&c)
-		if err != nil {
-			return domain.Config{}, err
+		problems := append(c.Problems(), l.problems...)
+		if errs := problems.Errors(); len(errs) > 0 {
+			return domain.Config{}, errs
 		}
+		c.Warnings = problems.Warnings()
+
+		return c, nil
+	}
+}
+
+// loader loads a config file and the files it includes, merging them into one config
+type loader struct {
+	rootDir  string
+	loaded   map[string]bool
+	files    []string
+	problems domain.Problems
+}
+
+// load reads the config at path, followed by its includes. name is how path is shown in problems, and is
+// empty for the top level config file
+func (l *loader) load(path string, name string) (domain.Config, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return domain.Config{}, err
+	}
+	if l.loaded[abs] {
+		return domain.Config{}, fmt.Errorf("%s is included more than once", path)
+	}
+	l.loaded[abs] = true
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return domain.Config{}, err
+	}
+	l.files = append(l.files, path)
 
-		configDir := filepath.Dir(f.Name())
-		if configDir != "/" {
-			configDir = configDir + "/"
+	c, err := decodeConfig(data, isYAML(path))
+	if err != nil {
+		if problems, ok := err.(domain.Problems); ok {
+			return domain.Config{}, problems.InFile(name)
+		}
+		if name != "" {
+			return domain.Config{}, fmt.Errorf("%s: %w", name, err)
 		}
+		return domain.Config{}, err
+	}
 
-		for _, r := range c.Routes {
-			if r.Type != domain.RouteTypeMock {
-				if r.Redirect != nil {
-					redirectType := r.Redirect.Type
-					if redirectType != "permanent" && redirectType != "temporary" {
-						return domain.Config{}, fmt.Errorf("invalid redirect type '%s'", redirectType)
-					}
-				}
+	dir := filepath.Dir(path)
+	configDir := dir
+	if configDir != "/" {
+		configDir = configDir + "/"
+	}
 
-				continue
+	if name != "" {
+		if c.Chaos != nil {
+			l.problems = append(l.problems, domain.Problem{File: name, Path: "chaos", Message: "chaos can only be set in the top level config"})
+		}
+		if c.CORS != nil {
+			l.problems = append(l.problems, domain.Problem{File: name, Path: "cors", Message: "cors can only be set in the top level config"})
+		}
+		if c.Transport != nil {
+			l.problems = append(l.problems, domain.Problem{File: name, Path: "transport", Message: "transport can only be set in the top level config"})
+		}
+		for profileName, profile := range c.Profiles {
+			if profile.DefaultBackend != nil {
+				l.problems = append(l.problems, domain.Problem{
+					File:    name,
+					Path:    fmt.Sprintf("profiles.%s.default_backend", profileName),
+					Message: "default_backend can only be set in the top level config",
+				})
 			}
+		}
+		for i := range c.Routes {
+			c.Routes[i].Origin = &domain.Origin{File: name, Path: fmt.Sprintf("routes[%d]", i)}
+		}
+		for i := range c.Servers {
+			c.Servers[i].Origin = &domain.Origin{File: name, Path: fmt.Sprintf("servers[%d]", i)}
+		}
+	}
+
+	files, problems := loadBodies(c.Routes, configDir, "routes")
+	l.files = append(l.files, files...)
+	l.problems = append(l.problems, problems.InFile(name)...)
+	for i, s := range c.Servers {
+		files, problems := loadBodies(s.Routes, configDir, fmt.Sprintf("servers[%d].routes", i))
+		l.files = append(l.files, files...)
+		l.problems = append(l.problems, problems.InFile(name)...)
+	}
+
+	l.problems = append(l.problems, resolveTransportFiles(c, dir).InFile(name)...)
+	l.problems = append(l.problems, resolveTokenFiles(c.Routes, dir, "routes").InFile(name)...)
+	for i, s := range c.Servers {
+		l.problems = append(l.problems, resolveTokenFiles(s.Routes, dir, fmt.Sprintf("servers[%d].routes", i)).InFile(name)...)
+	}
+
+	for i, include := range c.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(dir, include)
+		}
 
-			if r.Mock == nil {
-				return domain.Config{}, errors.New("missing mock config on mock type route")
+		matches := []string{include}
+		if strings.ContainsAny(include, "*?[") {
+			matches, err = filepath.Glob(include)
+			if err != nil {
+				l.problems = append(l.problems, domain.Problem{File: name, Path: fmt.Sprintf("include[%d]", i), Message: err.Error()})
+				continue
 			}
+			sort.Strings(matches)
+			// watch the directory so files added to it are included on reload
+			l.files = append(l.files, filepath.Dir(include))
+		} else if _, err := os.Stat(include); os.IsNotExist(err) {
+			l.problems = append(l.problems, domain.Problem{
+				File:    name,
+				Path:    fmt.Sprintf("include[%d]", i),
+				Message: fmt.Sprintf("included file '%s' not found", c.Include[i]),
+			})
+			continue
+		}
 
-			r.Mock.MatchRequest.Body, err = getBody(r.Mock.MatchRequest.Body, configDir)
+		for _, match := range matches {
+			fragment, err := l.load(match, l.name(match))
 			if err != nil {
 				return domain.Config{}, err
 			}
+			c.Routes = append(c.Routes, fragment.Routes...)
+			c.Servers = append(c.Servers, fragment.Servers...)
+			c.Profiles = mergeProfiles(c.Profiles, fragment.Profiles)
+		}
+	}
+
+	return c, nil
+}
+
+// mergeProfiles adds the route backends of an included file's profiles to the including file's profiles
+func mergeProfiles(profiles map[string]domain.Profile, included map[string]domain.Profile) map[string]domain.Profile {
+	for name, inc := range included {
+		if profiles == nil {
+			profiles = map[string]domain.Profile{}
+		}
+		profile := profiles[name]
+		for route, backend := range inc.Backends {
+			if profile.Backends == nil {
+				profile.Backends = map[string]*domain.Backend{}
+			}
+			profile.Backends[route] = backend
+		}
+		profiles[name] = profile
+	}
+	return profiles
+}
+
+// resolveTransportFiles makes the certificate files of every transport in the config relative to dir, which
+// is the directory of the file that declared them, returning a problem for each file that doesn't exist
+func resolveTransportFiles(c domain.Config, dir string) domain.Problems {
+	transports := map[string]*domain.Transport{"transport": c.Transport}
+	addBackend := func(path string, b *domain.Backend) {
+		if b != nil {
+			transports[domain.JoinPath(path, "transport")] = b.Transport
+		}
+	}
+	addRoutes := func(path string, routes []domain.Route) {
+		for i, r := range routes {
+			addBackend(fmt.Sprintf("%s[%d].backend", path, i), r.Backend)
+		}
+	}
+	addRoutes("routes", c.Routes)
+	for i, s := range c.Servers {
+		path := fmt.Sprintf("servers[%d]", i)
+		addBackend(domain.JoinPath(path, "default_backend"), s.DefaultBackend)
+		addRoutes(domain.JoinPath(path, "routes"), s.Routes)
+	}
+	for name, p := range c.Profiles {
+		path := domain.JoinPath("profiles", name)
+		addBackend(domain.JoinPath(path, "default_backend"), p.DefaultBackend)
+		for route, b := range p.Backends {
+			addBackend(domain.JoinPath(domain.JoinPath(path, "backends"), route), b)
+		}
+	}
+
+	paths := make([]string, 0, len(transports))
+	for path := range transports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var problems domain.Problems
+	for _, path := range paths {
+		t := transports[path]
+		if t == nil {
+			continue
+		}
+		files := []struct {
+			name string
+			file *string
+		}{
+			{"ca_file", &t.CAFile},
+			{"cert_file", &t.CertFile},
+			{"key_file", &t.KeyFile},
+		}
+		for _, f := range files {
+			declared := *f.file
+			if declared == "" {
+				continue
+			}
+			if !filepath.IsAbs(*f.file) {
+				*f.file = filepath.Join(dir, *f.file)
+			}
+			if _, err := os.Stat(*f.file); err != nil {
+				problems = append(problems, domain.Problem{
+					Path:    domain.JoinPath(path, f.name),
+					Message: fmt.Sprintf("%s '%s' not found", f.name, declared),
+				})
+			}
+		}
+	}
+	return problems
+}
+
+// resolveTokenFiles makes the bearer token files of routes relative to dir, returning a problem for each file
+// that doesn't exist
+func resolveTokenFiles(routes []domain.Route, dir string, path string) domain.Problems {
+	var problems domain.Problems
+	for i, r := range routes {
+		if r.Auth == nil || r.Auth.TokenFile == "" {
+			continue
+		}
+		declared := r.Auth.TokenFile
+		if !filepath.IsAbs(declared) {
+			r.Auth.TokenFile = filepath.Join(dir, declared)
+		}
+		if _, err := os.Stat(r.Auth.TokenFile); err != nil {
+			problems = append(problems, domain.Problem{
+				Path:    fmt.Sprintf("%s[%d].auth.token_file", path, i),
+				Message: fmt.Sprintf("token_file '%s' not found", declared),
+			})
+		}
+	}
+	return problems
+}
 
-			r.Mock.Response.Body, err = getBody(r.Mock.Response.Body, configDir)
+// name is how an included file is shown in problems, relative to the top level config file if it can be
+func (l *loader) name(path string) string {
+	if rel, err := filepath.Rel(l.rootDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// loadBodies replaces the mock bodies of routes that are paths to body files with the file contents,
+// returning the files that were read and a problem for each file that couldn't be
+func loadBodies(routes []domain.Route, configDir string, path string) ([]string, domain.Problems) {
+	var files []string
+	var problems domain.Problems
+	for i, r := range routes {
+		if r.Type != domain.RouteTypeMock || r.Mock == nil {
+			continue
+		}
+		mockPath := fmt.Sprintf("%s[%d].mock", path, i)
+
+		if isBodyFile(r.Mock.Response.Body, configDir) {
+			r.Mock.Response.ContentType = bodyContentType(r.Mock.Response.Body)
+
+			encoded, encodedFiles, err := getEncodedBodies(r.Mock.Response.Body, configDir)
 			if err != nil {
-				return domain.Config{}, err
+				problems = append(problems, domain.Problem{Path: mockPath + ".response.body", Message: err.Error()})
 			}
+			r.Mock.Response.EncodedBodies = encoded
+			files = append(files, encodedFiles...)
 		}
 
-		return c, nil
+		bodies := []struct {
+			path string
+			body *string
+		}{
+			{mockPath + ".request.body", &r.Mock.MatchRequest.Body},
+			{mockPath + ".response.body", &r.Mock.Response.Body},
+		}
+		for _, b := range bodies {
+			if !isBodyFile(*b.body, configDir) {
+				if hasBodyFileType(*b.body) {
+					problems = append(problems, domain.Problem{
+						Path:    b.path,
+						Message: fmt.Sprintf("no body file '%s', so it's used as the body itself", *b.body),
+						Warning: true,
+					})
+				}
+				continue
+			}
+			files = append(files, configDir+*b.body)
+
+			body, err := getBody(*b.body, configDir)
+			if err != nil {
+				problems = append(problems, domain.Problem{Path: b.path, Message: err.Error()})
+				continue
+			}
+			if interpolatedBodyFileTypes[strings.ToLower(filepath.Ext(*b.body))] && utf8.ValidString(body) {
+				body = interpolate(body)
+			}
+			*b.body = body
+		}
 	}
+	return files, problems
 }
 
-func getBody(body string, configDir string) (string, error) {
-	if !strings.HasSuffix(body, ".json") {
-		return body, nil
+// bodyFileTypes are the content types of the file extensions that mock bodies can be read from
+var bodyFileTypes = map[string]string{
+	".avif":  "image/avif",
+	".bin":   "application/octet-stream",
+	".css":   "text/css; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".gif":   "image/gif",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/x-icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "application/javascript",
+	".json":  "application/json",
+	".pb":    "application/x-protobuf",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".txt":   "text/plain; charset=utf-8",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "application/xml",
+}
+
+// interpolatedBodyFileTypes are the extensions of the body files that environment variables are replaced in.
+// Other files, such as scripts and stylesheets, are served as they are, since `${...}` can be part of their code
+var interpolatedBodyFileTypes = map[string]bool{
+	".json": true,
+	".txt":  true,
+	".xml":  true,
+}
+
+// encodedBodyExtensions are the extensions of pre-encoded variants of a body file, by content coding.
+// e.g. the gzip variant of mocks/logo.svg is mocks/logo.svg.gz
+var encodedBodyExtensions = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// isBodyFile checks the body is the path of a body file, relative to configDir, rather than the body itself.
+// It is if it ends in one of the body file extensions and the file exists
+func isBodyFile(body string, configDir string) bool {
+	if !hasBodyFileType(body) {
+		return false
 	}
+	info, err := os.Stat(configDir + body)
+	return err == nil && info.Mode().IsRegular()
+}
 
+func hasBodyFileType(body string) bool {
+	_, ok := bodyFileTypes[strings.ToLower(filepath.Ext(body))]
+	return ok
+}
+
+func bodyContentType(body string) string {
+	return bodyFileTypes[strings.ToLower(filepath.Ext(body))]
+}
+
+// getEncodedBodies reads any pre-encoded variants of a body file, returning them by content coding
+// along with the files they were read from
+func getEncodedBodies(body string, configDir string) (map[string]string, []string, error) {
+	var encoded map[string]string
+	var files []string
+	for coding, ext := range encodedBodyExtensions {
+		name := configDir + body + ext
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if encoded == nil {
+			encoded = map[string]string{}
+		}
+		encoded[coding] = string(b)
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return encoded, files, nil
+}
+
+func getBody(body string, configDir string) (string, error) {
 	f, err := os.Open(configDir + body)
 	if err != nil {
 		return "", err
 	}
+	defer f.Close()
 
 	b, err := ioutil.ReadAll(f)
 	if err != nil {
@@ -0,0 +1,311 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigProvider_BodyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	png := "\x89PNG\r\n\x1a\n\x00\x00"
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mocks"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mocks", "logo.png"), []byte(png), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mocks", "page.html"), []byte("<p>hi</p>"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mocks", "page.html.gz"), []byte("gzipped"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"routes": [
+		{"type": "mock", "mock": {"request": {"path": "/logo"}, "response": {"status": 200, "body": "mocks/logo.png"}}},
+		{"type": "mock", "mock": {"request": {"path": "/page"}, "response": {"status": 200, "body": "mocks/page.html"}}},
+		{"type": "mock", "mock": {"request": {"path": "/text"}, "response": {"status": 200, "body": "see logo.png"}}}
+	]}`), 0644))
+
+	conf, err := ConfigProvider()(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+
+	logo := conf.Routes[0].Mock.Response
+	assert.Equal(t, png, logo.Body)
+	assert.Equal(t, "image/png", logo.ContentType)
+	assert.Nil(t, logo.EncodedBodies)
+
+	page := conf.Routes[1].Mock.Response
+	assert.Equal(t, "<p>hi</p>", page.Body)
+	assert.Equal(t, "text/html; charset=utf-8", page.ContentType)
+	assert.Equal(t, map[string]string{"gzip": "gzipped"}, page.EncodedBodies)
+
+	text := conf.Routes[2].Mock.Response
+	assert.Equal(t, "see logo.png", text.Body)
+	assert.Equal(t, "", text.ContentType)
+
+	assert.Equal(t, []string{
+		filepath.Join(dir, "config.json"),
+		filepath.Join(dir, "mocks", "logo.png"),
+		filepath.Join(dir, "mocks", "page.html.gz"),
+		filepath.Join(dir, "mocks", "page.html"),
+	}, conf.Files)
+}
+
+func TestConfigProvider_Problems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"routes": [
+		{"type": "proxy", "path_pattern": "^/api/.*"},
+		{"type": "mock", "mock": {"request": {"path": "^/(.*"}, "response": {"status": 200, "body": "mocks/missing.json"}}}
+	]}`), 0644))
+
+	_, err = ConfigProvider()(filepath.Join(dir, "config.json"))
+	assert.Equal(t, domain.Problems{
+		{Path: "routes[0].backend", Message: "missing backend on proxy type route"},
+		{Path: "routes[1].mock.request.path", Message: "error parsing regexp: missing closing ): `^/(.*`"},
+	}, err)
+}
+
+func TestConfigProvider_LiteralAndFileBodies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mocks"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mocks", "saved basket.json"), []byte(`{"items": []}`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"routes": [
+		{"type": "mock", "mock": {"request": {"path": "^/basket$"}, "response": {"status": 200, "body": "mocks/saved basket.json"}}},
+		{"type": "mock", "mock": {"request": {"path": "^/health$"}, "response": {"status": 200, "body": "ok.txt"}}},
+		{"type": "mock", "mock": {"request": {"path": "^/help$"}, "response": {"status": 200, "body": "see config.json"}}}
+	]}`), 0644))
+
+	conf, err := ConfigProvider()(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+
+	// a body is read from a file when the file exists, even if its path has a space in it
+	assert.Equal(t, `{"items": []}`, conf.Routes[0].Mock.Response.Body)
+	assert.Equal(t, "application/json", conf.Routes[0].Mock.Response.ContentType)
+	// and is the body itself otherwise
+	assert.Equal(t, "ok.txt", conf.Routes[1].Mock.Response.Body)
+	assert.Equal(t, "", conf.Routes[1].Mock.Response.ContentType)
+	assert.Equal(t, "see config.json", conf.Routes[2].Mock.Response.Body)
+	assert.Equal(t, domain.Problems{
+		{Path: "routes[1].mock.response.body", Message: "no body file 'ok.txt', so it's used as the body itself", Warning: true},
+		{Path: "routes[2].mock.response.body", Message: "no body file 'see config.json', so it's used as the body itself", Warning: true},
+	}, conf.Warnings)
+}
+
+func TestConfigProvider_DecodeErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := map[string]string{
+		`{"routes": [{"type": "proxy", "path_pattern": "^/(.*"}]}`:                       "routes[0].path_pattern: error parsing regexp: missing closing ): `^/(.*`",
+		`{"servers": [{"port": 8443, "routes": [{"type": 1}]}]}`:                         "servers[0].routes[0].type: json: cannot unmarshal number into Go value of type string",
+		`{"routes": [{"type": "mock", "mock": {"response": {"headers": {"Link": 1}}}}]}`: "routes[0].mock.response.headers.Link: header value must be a string or a list of strings",
+		"{\"routes\": [\n  {\"type\": \"proxy\",}\n]}":                                   "line 2, column 20: invalid character '}' looking for beginning of object key string",
+	}
+	for config, expected := range tests {
+		t.Run(expected, func(t *testing.T) {
+			file := filepath.Join(dir, "config.json")
+			require.NoError(t, ioutil.WriteFile(file, []byte(config), 0644))
+
+			_, err := ConfigProvider()(file)
+			assert.EqualError(t, err, expected)
+		})
+	}
+}
+
+func TestConfigProvider_Warnings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"routes": [
+		{"type": "proxy", "path_pattern": "^/api/.*", "backend": "http://localhost:3000"},
+		{"type": "mock", "mock": {"request": {"path": "^/api/.*"}, "response": {"status": 200}}}
+	]}`), 0644))
+
+	conf, err := ConfigProvider()(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+	assert.EqualError(t, conf.Warnings, "routes[1]: unreachable, as route 0 has the same path_pattern '^/api/.*'")
+}
+
+func TestConfigProvider_YAMLIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "routes.d", "mocks"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "routes.d", "mocks", "basket.json"), []byte(`{"items": []}`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+# shared routes
+include:
+  - routes.d/*.yaml
+  - account.json
+routes:
+  - type: proxy
+    path_pattern: ^/test-ui/.*
+    backend: http://localhost:3000
+`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "routes.d", "b-checkout.yaml"), []byte(`
+routes:
+  - type: redirect
+    path_pattern: ^/checkout/(.*)
+    redirect: {to: /new-checkout/$1, type: temporary}
+`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "routes.d", "a-basket.yaml"), []byte(`
+routes:
+  - type: mock
+    mock:
+      request: {path: ^/basket$}
+      response:
+        status: 200
+        body: mocks/basket.json # relative to this file
+`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "account.json"), []byte(`{"routes": [
+		{"type": "proxy", "path_pattern": "^/account/.*", "backend": "http://localhost:3001"}
+	]}`), 0644))
+
+	conf, err := ConfigProvider()(filepath.Join(dir, "config.yaml"))
+	require.NoError(t, err)
+
+	require.Len(t, conf.Routes, 4)
+	assert.Equal(t, "^/test-ui/.*", conf.Routes[0].PathPattern.String())
+	assert.Nil(t, conf.Routes[0].Origin)
+	assert.Equal(t, `{"items": []}`, conf.Routes[1].Mock.Response.Body)
+	assert.Equal(t, &domain.Origin{File: "routes.d/a-basket.yaml", Path: "routes[0]"}, conf.Routes[1].Origin)
+	assert.Equal(t, "temporary", conf.Routes[2].Redirect.Type)
+	assert.Equal(t, "^/account/.*", conf.Routes[3].PathPattern.String())
+
+	assert.Equal(t, []string{
+		filepath.Join(dir, "config.yaml"),
+		filepath.Join(dir, "routes.d"),
+		filepath.Join(dir, "routes.d", "a-basket.yaml"),
+		filepath.Join(dir, "routes.d", "mocks", "basket.json"),
+		filepath.Join(dir, "routes.d", "b-checkout.yaml"),
+		filepath.Join(dir, "account.json"),
+	}, conf.Files)
+}
+
+func TestConfigProvider_IncludeProblems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+		"include": ["team.yaml", "missing.yaml"],
+		"routes": [{"type": "proxy", "path_pattern": "^/api/.*", "backend": "http://localhost:3000"}]
+	}`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "team.yaml"), []byte(`
+routes:
+  - type: proxy
+    path_pattern: ^/team/.*
+  - type: mock
+    mock: {request: {path: ^/api/.*}, response: {status: 200, body: mocks/missing.json}}
+`), 0644))
+
+	_, err = ConfigProvider()(filepath.Join(dir, "config.json"))
+	assert.Equal(t, domain.Problems{
+		{File: "team.yaml", Path: "routes[0].backend", Message: "missing backend on proxy type route"},
+		{Path: "include[1]", Message: "included file 'missing.yaml' not found"},
+	}, err)
+}
+
+func TestConfigProvider_Interpolation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.Setenv("UI_DEV_PROXY_TEST_ENV", "preprod"))
+	defer os.Unsetenv("UI_DEV_PROXY_TEST_ENV")
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mocks"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mocks", "user.json"), []byte(`{"env": "${UI_DEV_PROXY_TEST_ENV}"}`), 0644))
+	script := "const url = `https://api.${UI_DEV_PROXY_TEST_ENV}.example.com/${path:-}`"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mocks", "app.js"), []byte(script), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+routes:
+  - type: proxy
+    path_pattern: ^/api/.*
+    backend: https://api.${UI_DEV_PROXY_TEST_ENV}.example.com
+    proxy_pass_headers:
+      Referer: ${UI_DEV_PROXY_TEST_UNSET:-https://www.example.com}
+  - type: redirect
+    path_pattern: ^/old/(?P<UI_DEV_PROXY_TEST_ENV>.*)
+    redirect: {to: "/new/${UI_DEV_PROXY_TEST_ENV}", type: temporary}
+  - type: mock
+    mock:
+      request: {path: ^/user$}
+      response: {status: 200, body: mocks/user.json}
+  - type: mock
+    mock:
+      request: {path: ^/app.js$}
+      response: {status: 200, body: mocks/app.js}
+`), 0644))
+
+	conf, err := ConfigProvider()(filepath.Join(dir, "config.yaml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://api.preprod.example.com", conf.Routes[0].Backend.String())
+	assert.Equal(t, "https://www.example.com", conf.Routes[0].ProxyPassHeaders["Referer"])
+	// groups in redirects aren't replaced, even by a variable with the same name
+	assert.Equal(t, "/new/${UI_DEV_PROXY_TEST_ENV}", conf.Routes[1].Redirect.To)
+	assert.Equal(t, `{"env": "preprod"}`, conf.Routes[2].Mock.Response.Body)
+	// scripts are served unchanged, as their template literals look like variables
+	assert.Equal(t, script, conf.Routes[3].Mock.Response.Body)
+}
+
+func TestConfigProvider_Transport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.Setenv("UI_DEV_PROXY_TEST_ENV", "preprod"))
+	defer os.Unsetenv("UI_DEV_PROXY_TEST_ENV")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "routes.d", "certs"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "routes.d", "certs", "ca.pem"), []byte("ca"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+include: [routes.d/api.yaml]
+transport:
+  proxy: http://proxy.${UI_DEV_PROXY_TEST_ENV}.example.com:3128
+  response_header_timeout: 30s
+`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "routes.d", "api.yaml"), []byte(`
+routes:
+  - type: proxy
+    path_pattern: ^/api/.*
+    backend:
+      url: https://api.${UI_DEV_PROXY_TEST_ENV}.example.com
+      transport: {ca_file: certs/ca.pem, insecure_skip_verify: false}
+`), 0644))
+
+	conf, err := ConfigProvider()(filepath.Join(dir, "config.yaml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "http://proxy.preprod.example.com:3128", conf.Transport.Proxy)
+	assert.Equal(t, "https://api.preprod.example.com", conf.Routes[0].Backend.String())
+	assert.Equal(t, filepath.Join(dir, "routes.d", "certs", "ca.pem"), conf.Routes[0].Backend.Transport.CAFile)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "routes.d", "api.yaml"), []byte(`
+transport: {insecure_skip_verify: true}
+routes:
+  - type: proxy
+    path_pattern: ^/api/.*
+    backend:
+      url: https://api.example.com
+      transport: {cert_file: certs/client.pem, key_file: certs/client.key}
+`), 0644))
+
+	_, err = ConfigProvider()(filepath.Join(dir, "config.yaml"))
+	assert.Equal(t, domain.Problems{
+		{File: "routes.d/api.yaml", Path: "transport", Message: "transport can only be set in the top level config"},
+		{File: "routes.d/api.yaml", Path: "routes[0].backend.transport.cert_file", Message: "cert_file 'certs/client.pem' not found"},
+		{File: "routes.d/api.yaml", Path: "routes[0].backend.transport.key_file", Message: "key_file 'certs/client.key' not found"},
+	}, err)
+}
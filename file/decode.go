@@ -0,0 +1,150 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"gopkg.in/yaml.v2"
+)
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// decodeConfig decodes JSON or YAML config, reporting where in the config a value fails to decode.
+// Environment variables are interpolated before decoding, so they can be used in URLs
+func decodeConfig(data []byte, isYAML bool) (domain.Config, error) {
+	var tree interface{}
+	if isYAML {
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return domain.Config{}, err
+		}
+		tree = jsonValue(v)
+	} else if err := json.Unmarshal(data, &tree); err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			line, column := position(data, syntaxErr.Offset)
+			return domain.Config{}, fmt.Errorf("line %d, column %d: %w", line, column, err)
+		}
+		return domain.Config{}, err
+	}
+
+	interpolateConfig(tree)
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return domain.Config{}, err
+	}
+
+	var c domain.Config
+	err = json.Unmarshal(data, &c)
+	if err == nil {
+		return c, nil
+	}
+
+	path, pathErr := errorPath(data, reflect.TypeOf(c), "")
+	if pathErr == nil {
+		return domain.Config{}, err
+	}
+	return domain.Config{}, domain.Problems{{Path: path, Message: pathErr.Error()}}
+}
+
+// jsonValue converts the maps in a decoded YAML value to maps with string keys, which can be encoded as JSON
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+		return v
+	}
+	return v
+}
+
+// position returns the line and column of the last byte read before a syntax error at offset
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n') - 1
+	return line, column
+}
+
+// errorPath finds the JSON path of the innermost value in data that fails to decode into a value of type t,
+// along with its error
+func errorPath(data []byte, t reflect.Type, path string) (string, error) {
+	err := json.Unmarshal(data, reflect.New(t).Interface())
+	if err == nil {
+		return "", nil
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return path, err
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(data, &fields) != nil {
+			return path, err
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			// field names are matched case insensitively, as they are by encoding/json
+			for key, raw := range fields {
+				if !strings.EqualFold(key, name) {
+					continue
+				}
+				if p, fieldErr := errorPath(raw, f.Type, domain.JoinPath(path, key)); fieldErr != nil {
+					return p, fieldErr
+				}
+			}
+		}
+	case reflect.Slice:
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return path, err
+		}
+		for i, item := range items {
+			if p, itemErr := errorPath(item, t.Elem(), domain.JoinPath(path, fmt.Sprintf("[%d]", i))); itemErr != nil {
+				return p, itemErr
+			}
+		}
+	case reflect.Map:
+		var values map[string]json.RawMessage
+		if json.Unmarshal(data, &values) != nil {
+			return path, err
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if p, valueErr := errorPath(values[key], t.Elem(), domain.JoinPath(path, key)); valueErr != nil {
+				return p, valueErr
+			}
+		}
+	}
+	return path, err
+}
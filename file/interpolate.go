@@ -0,0 +1,108 @@
+package file
+
+import (
+	"os"
+	"regexp"
+)
+
+// variablePattern matches ${VAR} and ${VAR:-default}
+var variablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolate replaces ${VAR} with the environment variable VAR, and ${VAR:-default} with default when VAR
+// is unset or empty. ${VAR} is left as it is when VAR is unset
+func interpolate(s string) string {
+	return variablePattern.ReplaceAllStringFunc(s, func(match string) string {
+		parts := variablePattern.FindStringSubmatch(match)
+		value, ok := os.LookupEnv(parts[1])
+		hasDefault := parts[2] != ""
+		switch {
+		case ok && (value != "" || !hasDefault):
+			return value
+		case hasDefault:
+			return parts[3]
+		default:
+			return match
+		}
+	})
+}
+
+// interpolateConfig interpolates the backends, transport proxies, proxy pass headers, auth and mock bodies in a
+// decoded JSON config. Redirects aren't interpolated, as ${name} in redirect.to is a path_pattern group
+func interpolateConfig(config interface{}) {
+	interpolateField(field(config, "transport"), "proxy")
+	interpolateRoutes(field(config, "routes"))
+	for _, server := range items(field(config, "servers")) {
+		interpolateBackend(server, "default_backend")
+		interpolateRoutes(field(server, "routes"))
+	}
+	for _, profile := range values(field(config, "profiles")) {
+		interpolateBackend(profile, "default_backend")
+		backends, _ := field(profile, "backends").(map[string]interface{})
+		for name := range backends {
+			interpolateBackend(backends, name)
+		}
+	}
+}
+
+func interpolateRoutes(routes interface{}) {
+	for _, route := range items(routes) {
+		interpolateBackend(route, "backend")
+		interpolateValues(field(route, "proxy_pass_headers"))
+		interpolateValues(field(route, "auth"))
+		interpolateValues(field(field(route, "auth"), "params"))
+		interpolateField(field(field(route, "mock"), "request"), "body")
+		interpolateField(field(field(route, "mock"), "response"), "body")
+	}
+}
+
+// field returns the named field of a JSON object, or nil if v isn't an object
+func field(v interface{}, name string) interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m[name]
+	}
+	return nil
+}
+
+// items returns the items of a JSON array, or nil if v isn't an array
+func items(v interface{}) []interface{} {
+	a, _ := v.([]interface{})
+	return a
+}
+
+// values returns the values of a JSON object, or nil if v isn't an object
+func values(v interface{}) []interface{} {
+	m, _ := v.(map[string]interface{})
+	vals := make([]interface{}, 0, len(m))
+	for _, value := range m {
+		vals = append(vals, value)
+	}
+	return vals
+}
+
+// interpolateField interpolates the named field of a JSON object, if it's a string
+func interpolateField(v interface{}, name string) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if s, ok := m[name].(string); ok {
+		m[name] = interpolate(s)
+	}
+}
+
+// interpolateBackend interpolates the named backend of a JSON object, which is either a URL or an object with
+// a URL and transport settings
+func interpolateBackend(v interface{}, name string) {
+	interpolateField(v, name)
+	backend := field(v, name)
+	interpolateField(backend, "url")
+	interpolateField(field(backend, "transport"), "proxy")
+}
+
+// interpolateValues interpolates every string value of a JSON object
+func interpolateValues(v interface{}) {
+	m, _ := v.(map[string]interface{})
+	for name := range m {
+		interpolateField(m, name)
+	}
+}
@@ -0,0 +1,84 @@
+package file
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+// ConfigWatcher polls the config file and every body file it references, reloading the config through
+// provider whenever one of them changes. If the reloaded config fails to load the error is logged and
+// onChange isn't called, so the previous config stays active until the files are fixed
+func ConfigWatcher(provider domain.ConfigProvider, interval time.Duration, logger *log.Logger) domain.ConfigWatcher {
+	return func(path string, conf domain.Config, onChange func(domain.Config), stop <-chan struct{}) {
+		files := conf.Files
+		stamps := stampFiles(files)
+		lastErr := ""
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			latest := stampFiles(files)
+			if stampsEqual(stamps, latest) {
+				continue
+			}
+
+			newConf, err := provider(path)
+			if err != nil {
+				// keep retrying on every tick, as the fix may be in a file we aren't watching yet,
+				// but only log each distinct error once
+				if err.Error() != lastErr {
+					logger.Printf("failed to reload config, keeping previous config: %v\n", err)
+					lastErr = err.Error()
+				}
+				continue
+			}
+
+			lastErr = ""
+			files = newConf.Files
+			stamps = stampFiles(files)
+			onChange(newConf)
+		}
+	}
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func stampFiles(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			stamps[f] = fileStamp{}
+			continue
+		}
+		stamps[f] = fileStamp{modTime: info.ModTime(), size: info.Size(), exists: true}
+	}
+	return stamps
+}
+
+func stampsEqual(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for f, stamp := range a {
+		other, ok := b[f]
+		if !ok || !other.modTime.Equal(stamp.modTime) || other.size != stamp.size || other.exists != stamp.exists {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package file
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const watchedConfig = `{"routes": [{"type": "mock", "mock": {"request": {"path": "/a"}, "response": {"status": 200, "body": "body.json"}}}]}`
+
+func TestConfigWatcher_ReloadsOnBodyFileChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "config.json")
+	bodyPath := filepath.Join(dir, "body.json")
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(watchedConfig), 0644))
+	require.NoError(t, ioutil.WriteFile(bodyPath, []byte(`{"v": 1}`), 0644))
+
+	provider := ConfigProvider()
+	conf, err := provider(confPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{confPath, bodyPath}, conf.Files)
+
+	logger := log.New(ioutil.Discard, "", log.LstdFlags)
+	changes := make(chan domain.Config, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go ConfigWatcher(provider, 10*time.Millisecond, logger)(confPath, conf, func(c domain.Config) {
+		changes <- c
+	}, stop)
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, ioutil.WriteFile(bodyPath, []byte(`{"v": 22}`), 0644))
+
+	select {
+	case c := <-changes:
+		assert.Equal(t, `{"v": 22}`, c.Routes[0].Mock.Response.Body)
+	case <-time.After(2 * time.Second):
+		t.Fatal("config was not reloaded")
+	}
+}
+
+func TestConfigWatcher_KeepsConfigOnInvalidChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(`{"routes": []}`), 0644))
+
+	provider := ConfigProvider()
+	conf, err := provider(confPath)
+	require.NoError(t, err)
+
+	logger := log.New(ioutil.Discard, "", log.LstdFlags)
+	changes := make(chan domain.Config, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go ConfigWatcher(provider, 10*time.Millisecond, logger)(confPath, conf, func(c domain.Config) {
+		changes <- c
+	}, stop)
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(`{"routes": [`), 0644))
+
+	select {
+	case <-changes:
+		t.Fatal("invalid config should not be applied")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestConfigWatcher_WatchesNewlyIncludedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "config.json")
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "routes.d"), 0755))
+	require.NoError(t, ioutil.WriteFile(confPath, []byte(`{"include": ["routes.d/*.json"]}`), 0644))
+
+	provider := ConfigProvider()
+	conf, err := provider(confPath)
+	require.NoError(t, err)
+
+	logger := log.New(ioutil.Discard, "", log.LstdFlags)
+	changes := make(chan domain.Config, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go ConfigWatcher(provider, 10*time.Millisecond, logger)(confPath, conf, func(c domain.Config) {
+		changes <- c
+	}, stop)
+	time.Sleep(50 * time.Millisecond)
+
+	reloaded := func() domain.Config {
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(2 * time.Second):
+			t.Fatal("config was not reloaded")
+			return domain.Config{}
+		}
+	}
+
+	// a file added to an included directory is loaded, and then watched
+	fragment := filepath.Join(dir, "routes.d", "team.json")
+	require.NoError(t, ioutil.WriteFile(fragment, []byte(watchedConfig), 0644))
+	require.Len(t, reloaded().Routes, 1)
+
+	// write a different size, as the modification time may not have changed
+	require.NoError(t, ioutil.WriteFile(fragment, []byte(`{"routes": []}`), 0644))
+	assert.Len(t, reloaded().Routes, 0)
+}
@@ -0,0 +1,93 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+// ConfigWriter writes config as JSON to path. Mock bodies longer than bodyFileThreshold bytes, of a
+// type that ConfigProvider can read from a file, are written to sibling files. Binary bodies are always
+// written to files as they can't be stored in JSON
+func ConfigWriter(bodyFileThreshold int) domain.ConfigWriter {
+	return func(path string, conf domain.Config) error {
+		configDir := filepath.Dir(path)
+		bodyDir := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "_bodies"
+
+		routes := make([]domain.Route, len(conf.Routes))
+		for i, r := range conf.Routes {
+			routes[i] = r
+			if r.Mock == nil {
+				continue
+			}
+
+			mock := *r.Mock
+			routes[i].Mock = &mock
+
+			bodies := map[string]*string{
+				"request":  &mock.MatchRequest.Body,
+				"response": &mock.Response.Body,
+			}
+			for kind, body := range bodies {
+				var contentType string
+				if kind == "response" && len(mock.Response.Headers["Content-Type"]) > 0 {
+					contentType = mock.Response.Headers["Content-Type"][0]
+				}
+
+				binary := !utf8.ValidString(*body)
+				ext := bodyFileExtension(contentType, *body)
+				if ext == "" || (len(*body) <= bodyFileThreshold && !binary) {
+					continue
+				}
+
+				name := filepath.Join(bodyDir, fmt.Sprintf("%03d-%s%s", i, kind, ext))
+				if err := os.MkdirAll(filepath.Join(configDir, bodyDir), 0755); err != nil {
+					return err
+				}
+				if err := ioutil.WriteFile(filepath.Join(configDir, name), []byte(*body), 0644); err != nil {
+					return err
+				}
+				*body = filepath.ToSlash(name)
+			}
+		}
+
+		b, err := json.MarshalIndent(domain.Config{Routes: routes}, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(path, append(b, '\n'), 0644)
+	}
+}
+
+// bodyFileExtension returns the extension to write a body to so that ConfigProvider reads it back with
+// the same content type, or an empty string if the body can't be read back from a file
+func bodyFileExtension(contentType string, body string) string {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+
+	var exts []string
+	for ext, t := range bodyFileTypes {
+		if strings.SplitN(t, ";", 2)[0] == mediaType {
+			exts = append(exts, ext)
+		}
+	}
+	if len(exts) > 0 {
+		sort.Strings(exts)
+		return exts[0]
+	}
+
+	if json.Valid([]byte(body)) {
+		return ".json"
+	}
+	if !utf8.ValidString(body) {
+		return ".bin"
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigWriter_RoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	largeBody := `{"items": "` + strings.Repeat("x", 100) + `"}`
+	conf := domain.Config{Routes: []domain.Route{{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Method: "POST", Path: "^/basket$", Body: `{"sku": "1"}`},
+			Response: domain.Response{
+				Status:  200,
+				Body:    largeBody,
+				Headers: map[string]domain.HeaderValues{"X-Trace": {"abc"}, "Link": {"</a>", "</b>"}},
+				Cookies: []domain.Cookie{{Name: "SESSION", Value: "xyz"}},
+			},
+		},
+	}}}
+
+	path := filepath.Join(dir, "recorded.json")
+	require.NoError(t, ConfigWriter(64)(path, conf))
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "recorded_bodies", "000-response.json"))
+	require.NoError(t, err)
+	assert.Equal(t, largeBody, string(b))
+
+	loaded, err := ConfigProvider()(path)
+	require.NoError(t, err)
+
+	// the content type is inferred from the body file the large body was written to
+	conf.Routes[0].Mock.Response.ContentType = "application/json"
+	assert.Equal(t, conf.Routes, loaded.Routes)
+}
+
+func TestConfigWriter_BinaryBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	png := "\x89PNG\r\n\x1a\n\x00\x00"
+	conf := domain.Config{Routes: []domain.Route{{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Method: "GET", Path: "^/logo$"},
+			Response: domain.Response{
+				Status:  200,
+				Body:    png,
+				Headers: map[string]domain.HeaderValues{"Content-Type": {"image/png"}},
+			},
+		},
+	}}}
+
+	path := filepath.Join(dir, "recorded.json")
+	require.NoError(t, ConfigWriter(1024)(path, conf))
+
+	loaded, err := ConfigProvider()(path)
+	require.NoError(t, err)
+	assert.Equal(t, png, loaded.Routes[0].Mock.Response.Body)
+	assert.Equal(t, "image/png", loaded.Routes[0].Mock.Response.ContentType)
+}
@@ -1,9 +1,18 @@
 module github.com/JSainsburyPLC/ui-dev-proxy
 
-go 1.13
+go 1.24
 
 require (
 	github.com/steinfletcher/apitest v1.4.4
 	github.com/stretchr/testify v1.5.1
 	github.com/urfave/cli v1.22.1
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/cpuguy83/go-md2man/v2 v2.0.0-20190314233015-f79a8a8ca69d // indirect
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/russross/blackfriday/v2 v2.0.1 // indirect
+	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
 )
@@ -6,6 +6,7 @@ import (
 	"github.com/urfave/cli"
 	"log"
 	"os"
+	"time"
 )
 
 var version string
@@ -19,9 +20,13 @@ func main() {
 	app.Writer = logger.Writer()
 
 	confProvider := file.ConfigProvider()
+	confWatcher := file.ConfigWatcher(confProvider, time.Second, logger)
+	confWriter := file.ConfigWriter(1024)
 
 	app.Commands = []cli.Command{
-		commands.StartCommand(logger, confProvider),
+		commands.StartCommand(logger, confProvider, confWatcher, confWriter),
+		commands.ExplainCommand(confProvider),
+		commands.ValidateCommand(confProvider),
 	}
 
 	err := app.Run(os.Args)
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+const accessLogCtxKey = "access_log"
+
+const (
+	AccessLogFormatJSON   = "json"
+	AccessLogFormatLogfmt = "logfmt"
+)
+
+// access log levels, from least to most severe. Entries are info, warn for 4xx statuses,
+// and error for 5xx statuses or when the proxy failed to handle the request
+var accessLogLevels = map[string]int{
+	"info":  0,
+	"warn":  1,
+	"error": 2,
+}
+
+// actions taken for a request, in the access log
+const (
+	actionDefault  = "default"
+	actionProxy    = "proxy"
+	actionRedirect = "redirect"
+	actionMock     = "mock"
+	// actionPreflight is a CORS preflight request answered by the proxy
+	actionPreflight = "preflight"
+)
+
+// AccessLogEntry describes how a request was handled
+type AccessLogEntry struct {
+	Time     time.Time `json:"time"`
+	Level    string    `json:"level"`
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	Protocol string    `json:"protocol"`
+	Server   string    `json:"server,omitempty"`
+	// RouteIndex is the index of the matched route, or -1 if no route matched
+	RouteIndex int    `json:"route_index"`
+	RouteType  string `json:"route_type,omitempty"`
+	RouteName  string `json:"route_name,omitempty"`
+	// Action is how the request was handled: default, proxy, redirect, mock or preflight
+	Action string `json:"action,omitempty"`
+	// Backend is the URL the request was proxied to, after any rewrite
+	Backend string `json:"backend,omitempty"`
+	// RedirectTo is the location of a redirect
+	RedirectTo string  `json:"redirect_to,omitempty"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	LatencyMs  float64 `json:"latency_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// AccessLog writes an entry for every request, as JSON or logfmt lines
+type AccessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	level  int
+}
+
+func NewAccessLog(w io.Writer, format string, level string) (*AccessLog, error) {
+	if format != AccessLogFormatJSON && format != AccessLogFormatLogfmt {
+		return nil, fmt.Errorf("unknown access log format '%s'", format)
+	}
+	l, ok := accessLogLevels[level]
+	if !ok {
+		return nil, fmt.Errorf("unknown access log level '%s'", level)
+	}
+	return &AccessLog{w: w, format: format, level: l}, nil
+}
+
+// AccessLog starts writing an entry to a for every request
+func (p *Proxy) AccessLog(a *AccessLog) {
+	next := p.server.Handler
+	p.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, entry := withAccessLogEntry(r)
+		rw := &accessLogWriter{ResponseWriter: w}
+
+		defer func() {
+			entry.complete(rw, start)
+			a.write(entry)
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func (a *AccessLog) write(e *AccessLogEntry) {
+	if accessLogLevels[e.Level] < a.level {
+		return
+	}
+
+	var line []byte
+	if a.format == AccessLogFormatLogfmt {
+		line = []byte(e.logfmt())
+	} else {
+		line, _ = json.Marshal(e)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, _ = a.w.Write(append(line, '\n'))
+}
+
+func (e *AccessLogEntry) logfmt() string {
+	fields := []struct {
+		key   string
+		value string
+	}{
+		{"time", e.Time.Format(time.RFC3339Nano)},
+		{"level", e.Level},
+		{"method", e.Method},
+		{"url", e.URL},
+		{"protocol", e.Protocol},
+		{"server", e.Server},
+		{"route_index", strconv.Itoa(e.RouteIndex)},
+		{"route_type", e.RouteType},
+		{"route_name", e.RouteName},
+		{"action", e.Action},
+		{"backend", e.Backend},
+		{"redirect_to", e.RedirectTo},
+		{"status", strconv.Itoa(e.Status)},
+		{"bytes", strconv.FormatInt(e.Bytes, 10)},
+		{"latency_ms", strconv.FormatFloat(e.LatencyMs, 'f', 3, 64)},
+		{"error", e.Error},
+	}
+
+	var parts []string
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		value := f.value
+		if strings.ContainsAny(value, " =\"\\\t\n") {
+			value = strconv.Quote(value)
+		}
+		parts = append(parts, f.key+"="+value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// withAccessLogEntry returns the request's access log entry, adding a new one to the request if it doesn't
+// have one yet
+func withAccessLogEntry(r *http.Request) (*http.Request, *AccessLogEntry) {
+	if e, ok := r.Context().Value(accessLogCtxKey).(*AccessLogEntry); ok {
+		return r, e
+	}
+	e := &AccessLogEntry{
+		Time:       time.Now(),
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Protocol:   r.Proto,
+		RouteIndex: -1,
+	}
+	return r.WithContext(context.WithValue(r.Context(), accessLogCtxKey, e)), e
+}
+
+// complete records the response written to w, and the level of the entry
+func (e *AccessLogEntry) complete(w *accessLogWriter, start time.Time) {
+	e.Status = w.status
+	e.Bytes = w.bytes
+	e.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+
+	e.Level = "info"
+	switch {
+	case e.Status >= 500 || e.Error != "":
+		e.Level = "error"
+	case e.Status >= 400:
+		e.Level = "warn"
+	}
+}
+
+// accessLogEntry returns the request's access log entry, or a throwaway entry if access logging is off
+func accessLogEntry(r *http.Request) *AccessLogEntry {
+	if e, ok := r.Context().Value(accessLogCtxKey).(*AccessLogEntry); ok {
+		return e
+	}
+	return &AccessLogEntry{}
+}
+
+func (e *AccessLogEntry) setRoute(server *domain.Server, index int, route *domain.Route) {
+	e.Server = serverName(server)
+	e.RouteIndex = index
+	if route != nil {
+		e.RouteType = route.Type
+		e.RouteName = route.Name
+	}
+}
+
+// accessLogWriter records the status and number of body bytes written, and captures the body if capture is set
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	// capture holds the first capturedBodyLimit bytes of the body
+	capture *bytes.Buffer
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	// informational responses other than protocol switches are followed by the final status
+	if w.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	if w.capture != nil && w.capture.Len() < capturedBodyLimit {
+		w.capture.Write(b[:n])
+	}
+	return n, err
+}
+
+func (w *accessLogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/steinfletcher/apitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_AccessLog_JSON(t *testing.T) {
+	backend, _ := url.Parse("http://localhost:3001")
+	conf := configWithRoutes(domain.Route{
+		Name:        "users",
+		Type:        domain.RouteTypeProxy,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/test-ui/.*")},
+		Backend:     &domain.Backend{URL: backend},
+		Rewrite: []domain.Rewrite{{
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/test-ui/(.*)")},
+			To:          "/$1",
+		}},
+	})
+
+	var buf bytes.Buffer
+	p := newTestProxy(conf, withAccessLog(&buf, AccessLogFormatJSON, "info"))
+
+	apitest.New().
+		Handler(p.server.Handler).
+		Mocks(apitest.NewMock().
+			Get("http://localhost:3001/users/info").
+			RespondWith().
+			Status(http.StatusOK).
+			Body(`{"user_id": "123"}`).
+			End()).
+		Get("/test-ui/users/info").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+
+	var entry AccessLogEntry
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "info", entry.Level)
+	assert.Equal(t, http.MethodGet, entry.Method)
+	assert.Equal(t, "/test-ui/users/info", entry.URL)
+	assert.Equal(t, 0, entry.RouteIndex)
+	assert.Equal(t, "users", entry.RouteName)
+	assert.Equal(t, actionProxy, entry.Action)
+	assert.Equal(t, "http://localhost:3001/users/info", entry.Backend)
+	assert.Equal(t, http.StatusOK, entry.Status)
+	assert.Equal(t, int64(len(`{"user_id": "123"}`)), entry.Bytes)
+}
+
+func TestProxy_AccessLog_LogfmtLevel(t *testing.T) {
+	conf := configWithRoutes(domain.Route{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Path: "^/missing$"},
+			Response:     domain.Response{Status: http.StatusNotFound, Body: "not found"},
+		},
+	}, domain.Route{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Path: "^/found$"},
+			Response:     domain.Response{Status: http.StatusOK, Body: "found"},
+		},
+	})
+
+	var buf bytes.Buffer
+	p := newTestProxy(conf, withAccessLog(&buf, AccessLogFormatLogfmt, "warn"))
+
+	for _, path := range []string{"/found", "/missing"} {
+		apitest.New().
+			Handler(p.server.Handler).
+			Get(path).
+			Expect(t).
+			End()
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], " level=warn method=GET url=/missing protocol=HTTP/1.1 route_index=0 route_type=mock action=mock status=404 bytes=9 ")
+}
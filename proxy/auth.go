@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+const authCtxKey = "authorization"
+
+// tokenExpiryMargin is how long before it expires a cached OAuth2 token is replaced, so it doesn't expire
+// on the way to the backend
+const tokenExpiryMargin = 30 * time.Second
+
+// authenticator works out the Authorization header for a route's auth, caching OAuth2 tokens until they expire
+type authenticator struct {
+	client *http.Client
+	now    func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]*cachedToken
+}
+
+// cachedToken is the token for an auth. Its lock is held while the token is fetched, so a slow token endpoint
+// only holds up the requests that need its token
+type cachedToken struct {
+	mu    sync.Mutex
+	token *oauth2Token
+}
+
+type oauth2Token struct {
+	accessToken string
+	tokenType   string
+	// expiry is zero if the token endpoint didn't say when it expires
+	expiry time.Time
+	// refreshToken replaces the configured one, if the token endpoint issued a new one
+	refreshToken string
+}
+
+// newAuthenticator returns an authenticator that fetches tokens with the transport, which sends them with the
+// transport settings of the route being authenticated
+func newAuthenticator(transport http.RoundTripper) *authenticator {
+	return &authenticator{
+		client: &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		now:    time.Now,
+		tokens: map[string]*cachedToken{},
+	}
+}
+
+// header returns the Authorization header value for the auth. Any token is fetched with the transport settings
+func (a *authenticator) header(auth *domain.Auth, settings *domain.Transport) (string, error) {
+	switch auth.Type {
+	case domain.AuthTypeBasic:
+		credentials := auth.Username + ":" + auth.Password
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)), nil
+	case domain.AuthTypeBearer:
+		token, err := bearerToken(auth)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	case domain.AuthTypeOAuth2:
+		token, err := a.oauth2Token(auth, tokenTransport(auth, settings))
+		if err != nil {
+			return "", err
+		}
+		tokenType := token.tokenType
+		if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+			tokenType = "Bearer"
+		}
+		return tokenType + " " + token.accessToken, nil
+	}
+	return "", fmt.Errorf("unknown auth type '%s'", auth.Type)
+}
+
+// tokenTransport returns the transport settings to fetch the auth's tokens with, which are the backend's without
+// its client certificate, unless the auth asks for it
+func tokenTransport(auth *domain.Auth, settings *domain.Transport) *domain.Transport {
+	if settings == nil || settings.CertFile == "" || auth.TokenClientCert {
+		return settings
+	}
+	withoutCert := *settings
+	withoutCert.CertFile = ""
+	withoutCert.KeyFile = ""
+	return &withoutCert
+}
+
+// reset forgets the cached tokens, so that those for auth that's no longer configured aren't kept
+func (a *authenticator) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tokens = map[string]*cachedToken{}
+}
+
+// bearerToken returns the configured token, or reads it from the token file or environment variable
+func bearerToken(auth *domain.Auth) (string, error) {
+	switch {
+	case auth.TokenFile != "":
+		b, err := ioutil.ReadFile(auth.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read token_file: %w", err)
+		}
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return "", fmt.Errorf("token_file '%s' is empty", auth.TokenFile)
+		}
+		return token, nil
+	case auth.TokenEnv != "":
+		token := strings.TrimSpace(os.Getenv(auth.TokenEnv))
+		if token == "" {
+			return "", fmt.Errorf("environment variable %s is empty", auth.TokenEnv)
+		}
+		return token, nil
+	}
+	return auth.Token, nil
+}
+
+// oauth2Token returns the cached token for the auth, fetching a new one if there isn't one or it's expiring.
+// Fetches for the same auth are made one at a time, so concurrent requests share a token rather than each
+// fetching their own
+func (a *authenticator) oauth2Token(auth *domain.Auth, settings *domain.Transport) (*oauth2Token, error) {
+	key, err := json.Marshal(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	cached, ok := a.tokens[string(key)]
+	if !ok {
+		cached = &cachedToken{}
+		a.tokens[string(key)] = cached
+	}
+	a.mu.Unlock()
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	if cached.token != nil && (cached.token.expiry.IsZero() || a.now().Add(tokenExpiryMargin).Before(cached.token.expiry)) {
+		return cached.token, nil
+	}
+
+	refreshToken := auth.RefreshToken
+	if cached.token != nil && cached.token.refreshToken != "" {
+		refreshToken = cached.token.refreshToken
+	}
+	token, err := a.fetchToken(auth, settings, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if token.refreshToken == "" {
+		token.refreshToken = refreshToken
+	}
+	cached.token = token
+	return token, nil
+}
+
+// fetchToken requests a token from the token endpoint, authenticating the client with basic auth
+func (a *authenticator) fetchToken(auth *domain.Auth, settings *domain.Transport, refreshToken string) (*oauth2Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if refreshToken != "" {
+		form = url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}}
+	}
+	if len(auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(auth.Scopes, " "))
+	}
+	for name, value := range auth.Params {
+		form.Set(name, value)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, auth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(auth.ClientID), url.QueryEscape(auth.ClientSecret))
+	req = withTransport(req, settings)
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch oauth2 token: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch oauth2 token: %w", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("oauth2 token endpoint responded with %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var tokenRes struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(body, &tokenRes); err != nil {
+		return nil, fmt.Errorf("invalid oauth2 token response: %w", err)
+	}
+	if tokenRes.AccessToken == "" {
+		return nil, fmt.Errorf("oauth2 token response has no access_token")
+	}
+
+	token := &oauth2Token{
+		accessToken:  tokenRes.AccessToken,
+		tokenType:    tokenRes.TokenType,
+		refreshToken: tokenRes.RefreshToken,
+	}
+	if tokenRes.ExpiresIn > 0 {
+		token.expiry = a.now().Add(time.Duration(tokenRes.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
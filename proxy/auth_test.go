@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/certs"
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_Auth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer backend.Close()
+
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0644))
+
+	require.NoError(t, os.Setenv("UI_DEV_PROXY_TEST_TOKEN", "env-token"))
+	defer os.Unsetenv("UI_DEV_PROXY_TEST_TOKEN")
+
+	backendURL, _ := url.Parse(backend.URL)
+	route := func(pattern string, auth *domain.Auth) domain.Route {
+		return domain.Route{
+			Type:        domain.RouteTypeProxy,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile(pattern)},
+			Backend:     &domain.Backend{URL: backendURL},
+			Auth:        auth,
+		}
+	}
+	p := newTestProxy(configWithRoutes(
+		route("^/basic$", &domain.Auth{Type: domain.AuthTypeBasic, Username: "user", Password: "secret"}),
+		route("^/bearer$", &domain.Auth{Type: domain.AuthTypeBearer, Token: "static-token"}),
+		route("^/bearer-file$", &domain.Auth{Type: domain.AuthTypeBearer, TokenFile: tokenFile}),
+		route("^/bearer-env$", &domain.Auth{Type: domain.AuthTypeBearer, TokenEnv: "UI_DEV_PROXY_TEST_TOKEN"}),
+		route("^/bearer-missing$", &domain.Auth{Type: domain.AuthTypeBearer, TokenEnv: "UI_DEV_PROXY_TEST_UNSET"}),
+		route("^/none$", nil),
+	))
+
+	tests := map[string]struct {
+		status int
+		body   string
+	}{
+		"/basic":          {http.StatusOK, "Basic dXNlcjpzZWNyZXQ="},
+		"/bearer":         {http.StatusOK, "Bearer static-token"},
+		"/bearer-file":    {http.StatusOK, "Bearer file-token"},
+		"/bearer-env":     {http.StatusOK, "Bearer env-token"},
+		"/bearer-missing": {http.StatusBadGateway, "Bad gateway"},
+		"/none":           {http.StatusOK, "Bearer from-client"},
+	}
+	for path, expected := range tests {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil)
+			req.Header.Set("Authorization", "Bearer from-client")
+			w := httptest.NewRecorder()
+			p.server.Handler.ServeHTTP(w, req)
+			assert.Equal(t, expected.status, w.Code)
+			assert.Equal(t, expected.body, w.Body.String())
+		})
+	}
+}
+
+func TestProxy_Auth_OAuth2(t *testing.T) {
+	var tokenRequests []url.Values
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != "client" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+		_ = r.ParseForm()
+		tokenRequests = append(tokenRequests, r.PostForm)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("token-%d", len(tokenRequests)),
+			"token_type":    "bearer",
+			"expires_in":    3600,
+			"refresh_token": fmt.Sprintf("refresh-%d", len(tokenRequests)),
+		})
+	}))
+	defer tokenServer.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	route := func(pattern string, auth *domain.Auth) domain.Route {
+		return domain.Route{
+			Type:        domain.RouteTypeProxy,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile(pattern)},
+			Backend:     &domain.Backend{URL: backendURL},
+			Auth:        auth,
+		}
+	}
+	p := newTestProxy(configWithRoutes(
+		route("^/client$", &domain.Auth{
+			Type:         domain.AuthTypeOAuth2,
+			TokenURL:     tokenServer.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Scopes:       []string{"read", "write"},
+			Params:       map[string]string{"audience": "api"},
+		}),
+		route("^/refresh$", &domain.Auth{
+			Type:         domain.AuthTypeOAuth2,
+			TokenURL:     tokenServer.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RefreshToken: "configured-refresh",
+		}),
+		route("^/wrong-secret$", &domain.Auth{Type: domain.AuthTypeOAuth2, TokenURL: tokenServer.URL, ClientID: "client"}),
+	))
+	now := time.Now()
+	p.auth.now = func() time.Time { return now }
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+		return w
+	}
+
+	// the token is fetched once, and cached until it's about to expire
+	assert.Equal(t, "Bearer token-1", get("/client").Body.String())
+	assert.Equal(t, "Bearer token-1", get("/client").Body.String())
+	now = now.Add(time.Hour - time.Minute)
+	assert.Equal(t, "Bearer token-1", get("/client").Body.String())
+	now = now.Add(time.Minute)
+	assert.Equal(t, "Bearer token-2", get("/client").Body.String())
+
+	require.Len(t, tokenRequests, 2)
+	assert.Equal(t, url.Values{"grant_type": {"client_credentials"}, "scope": {"read write"}, "audience": {"api"}}, tokenRequests[0])
+
+	// refreshing uses the configured refresh token, then the one issued with the last token
+	assert.Equal(t, "Bearer token-3", get("/refresh").Body.String())
+	now = now.Add(time.Hour)
+	assert.Equal(t, "Bearer token-4", get("/refresh").Body.String())
+	assert.Equal(t, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"configured-refresh"}}, tokenRequests[2])
+	assert.Equal(t, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"refresh-3"}}, tokenRequests[3])
+
+	w := get("/wrong-secret")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Len(t, tokenRequests, 4)
+}
+
+func TestProxy_Auth_OAuth2_Transport(t *testing.T) {
+	tokenServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "tls-token", "expires_in": 3600})
+	}))
+	defer tokenServer.Close()
+
+	release := make(chan struct{})
+	hangingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "slow-token"})
+	}))
+	defer hangingServer.Close()
+	defer close(release)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer backend.Close()
+
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "token-server.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tokenServer.Certificate().Raw}), 0644))
+
+	backendURL, _ := url.Parse(backend.URL)
+	route := func(pattern string, tokenURL string, transport *domain.Transport) domain.Route {
+		return domain.Route{
+			Type:        domain.RouteTypeProxy,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile(pattern)},
+			Backend:     &domain.Backend{URL: backendURL, Transport: transport},
+			Auth:        &domain.Auth{Type: domain.AuthTypeOAuth2, TokenURL: tokenURL, ClientID: "client"},
+		}
+	}
+	p := newTestProxy(configWithRoutes(
+		route("^/trusted$", tokenServer.URL, &domain.Transport{CAFile: caFile}),
+		route("^/untrusted$", tokenServer.URL+"/untrusted", nil),
+		route("^/hanging$", hangingServer.URL, nil),
+	))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+		return w
+	}
+
+	// a token endpoint that never responds doesn't hold up the other routes' tokens
+	go get("/hanging")
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// the token is fetched with the route's transport, which trusts the token endpoint's certificate
+		assert.Equal(t, "Bearer tls-token", get("/trusted").Body.String())
+		assert.Equal(t, http.StatusBadGateway, get("/untrusted").Code)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("token fetch was held up by another route's token endpoint")
+	}
+}
+
+func TestProxy_Auth_OAuth2_ClientCert(t *testing.T) {
+	tokenServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := "without-cert"
+		if len(r.TLS.PeerCertificates) > 0 {
+			token = "with-cert"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": token})
+	}))
+	tokenServer.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	tokenServer.StartTLS()
+	defer tokenServer.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer backend.Close()
+
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "token-server.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tokenServer.Certificate().Raw}), 0644))
+
+	authority, err := certs.LoadOrCreateAuthority(dir)
+	require.NoError(t, err)
+	client, err := authority.GetCertificate(&tls.ClientHelloInfo{ServerName: "client.example.com"})
+	require.NoError(t, err)
+	key, err := x509.MarshalECPrivateKey(client.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: client.Certificate[0]}), 0644))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600))
+
+	backendURL, _ := url.Parse(backend.URL)
+	transport := &domain.Transport{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}
+	route := func(pattern string, clientCert bool) domain.Route {
+		return domain.Route{
+			Type:        domain.RouteTypeProxy,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile(pattern)},
+			Backend:     &domain.Backend{URL: backendURL, Transport: transport},
+			Auth: &domain.Auth{
+				Type:            domain.AuthTypeOAuth2,
+				TokenURL:        tokenServer.URL,
+				ClientID:        "client",
+				TokenClientCert: clientCert,
+			},
+		}
+	}
+	conf := configWithRoutes(route("^/backend-cert$", false), route("^/token-cert$", true))
+	p := newTestProxy(conf)
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		p.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+		return w.Body.String()
+	}
+
+	// the backend's client certificate is only sent to the token endpoint when asked for
+	assert.Equal(t, "Bearer without-cert", get("/backend-cert"))
+	assert.Equal(t, "Bearer with-cert", get("/token-cert"))
+
+	p.SetConfig(conf)
+	assert.Empty(t, p.auth.tokens)
+}
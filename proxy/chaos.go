@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+const (
+	// throttled writes are flushed this often
+	throttleInterval = 100 * time.Millisecond
+	// bodies of unknown length are truncated after this many bytes
+	defaultTruncateAt = 512
+)
+
+// roller returns a random number in [0, 1). It's safe for concurrent use
+type roller func() float64
+
+func newRoller() roller {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Float64()
+	}
+}
+
+// applyChaos delays, fails or resets the request according to chaos, before the response is written.
+// It returns the writer to write the response with, which throttles or truncates the body, and false
+// if a response has already been sent
+func applyChaos(
+	chaos *domain.Chaos,
+	w http.ResponseWriter,
+	r *http.Request,
+	roll roller,
+	logger *log.Logger,
+) (http.ResponseWriter, bool) {
+	if chaos == nil {
+		return w, true
+	}
+
+	latency := chaos.Latency.Value()
+	if max := chaos.MaxLatency.Value(); max > latency {
+		latency += time.Duration(roll() * float64(max-latency))
+	}
+	if latency > 0 {
+		logger.Printf("chaos: delaying response by %s\n", latency)
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return w, false
+		}
+	}
+
+	if chaos.ResetRate > 0 && roll() < chaos.ResetRate {
+		logger.Println("chaos: resetting connection")
+		// aborting the handler closes the connection without a response
+		panic(http.ErrAbortHandler)
+	}
+
+	if chaos.ErrorRate > 0 && roll() < chaos.ErrorRate {
+		status := chaos.ErrorStatus
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		logger.Printf("chaos: responding with error status %d\n", status)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(http.StatusText(status)))
+		return w, false
+	}
+
+	truncate := chaos.TruncateRate > 0 && roll() < chaos.TruncateRate
+	if chaos.BytesPerSecond == 0 && !truncate {
+		return w, true
+	}
+
+	if truncate {
+		logger.Println("chaos: truncating response body")
+	}
+	return &chaosWriter{
+		ResponseWriter: w,
+		bytesPerSecond: chaos.BytesPerSecond,
+		truncate:       truncate,
+		truncateAt:     -1,
+	}, true
+}
+
+// chaosWriter throttles the response body, and closes the connection part way through it when truncating
+type chaosWriter struct {
+	http.ResponseWriter
+	bytesPerSecond int
+	truncate       bool
+	truncateAt     int
+	written        int
+}
+
+func (c *chaosWriter) WriteHeader(status int) {
+	if c.truncate {
+		// truncate half way through the body if its length is known
+		c.truncateAt = defaultTruncateAt
+		if length, err := strconv.Atoi(c.Header().Get("Content-Length")); err == nil {
+			c.truncateAt = length / 2
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *chaosWriter) Write(b []byte) (int, error) {
+	if c.truncate && c.truncateAt == -1 {
+		c.WriteHeader(http.StatusOK)
+	}
+
+	total := 0
+	for len(b) > 0 {
+		chunk := len(b)
+		if c.bytesPerSecond > 0 && chunk > c.throttleChunkSize() {
+			chunk = c.throttleChunkSize()
+		}
+		if c.truncate && c.written+chunk > c.truncateAt {
+			chunk = c.truncateAt - c.written
+		}
+
+		n, err := c.ResponseWriter.Write(b[:chunk])
+		total += n
+		c.written += n
+		if err != nil {
+			return total, err
+		}
+		b = b[chunk:]
+
+		if c.truncate && c.written >= c.truncateAt {
+			c.Flush()
+			// aborting the handler closes the connection part way through the body
+			panic(http.ErrAbortHandler)
+		}
+
+		if c.bytesPerSecond > 0 {
+			c.Flush()
+			time.Sleep(time.Duration(float64(chunk) / float64(c.bytesPerSecond) * float64(time.Second)))
+		}
+	}
+	return total, nil
+}
+
+// throttleChunkSize is the number of bytes to write each throttle interval
+func (c *chaosWriter) throttleChunkSize() int {
+	n := int(float64(c.bytesPerSecond) * throttleInterval.Seconds())
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (c *chaosWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (c *chaosWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
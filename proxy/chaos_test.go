@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chaosMockConfig(chaos *domain.Chaos, body string) domain.Config {
+	return configWithRoutes(domain.Route{
+		Type: "mock",
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Method: "GET", Path: "^/api/users/.*"},
+			Response:     domain.Response{Status: http.StatusOK, Body: body},
+		},
+		Chaos: chaos,
+	})
+}
+
+func TestProxy_Chaos_ErrorStatus(t *testing.T) {
+	conf := chaosMockConfig(&domain.Chaos{ErrorRate: 1, ErrorStatus: http.StatusServiceUnavailable}, `{"name": "jon"}`)
+
+	newApiTest(conf, "http://test-backend", true).
+		Get("/api/users/info").
+		Expect(t).
+		Status(http.StatusServiceUnavailable).
+		End()
+}
+
+func TestProxy_Chaos_DefaultBackend(t *testing.T) {
+	conf := config()
+	conf.Chaos = &domain.Chaos{ErrorRate: 1}
+
+	newApiTest(conf, "http://test-backend", false).
+		Get("/original-ui/product").
+		Expect(t).
+		Status(http.StatusInternalServerError).
+		End()
+}
+
+func TestProxy_Chaos_RouteOverridesGlobal(t *testing.T) {
+	conf := chaosMockConfig(&domain.Chaos{}, `{"name": "jon"}`)
+	conf.Chaos = &domain.Chaos{ErrorRate: 1}
+
+	newApiTest(conf, "http://test-backend", true).
+		Get("/api/users/info").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"name": "jon"}`).
+		End()
+}
+
+func TestProxy_Chaos_Redirect(t *testing.T) {
+	conf := configWithRoutes(domain.Route{
+		Type:        "redirect",
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("/test-ui/(.*)")},
+		Redirect:    &domain.Redirect{To: "http://www.domain2.com/$1", Type: "temporary"},
+		Chaos:       &domain.Chaos{ErrorRate: 1, ErrorStatus: http.StatusBadGateway},
+	})
+
+	newApiTest(conf, "http://test-backend", false).
+		Get("/test-ui/users/info").
+		Expect(t).
+		Status(http.StatusBadGateway).
+		End()
+}
+
+func TestProxy_Chaos_Latency(t *testing.T) {
+	latency := &domain.Duration{Duration: 50 * time.Millisecond}
+	conf := chaosMockConfig(&domain.Chaos{Latency: latency}, `{"name": "jon"}`)
+
+	start := time.Now()
+	newApiTest(conf, "http://test-backend", true).
+		Get("/api/users/info").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestProxy_Chaos_Throttle(t *testing.T) {
+	conf := chaosMockConfig(&domain.Chaos{BytesPerSecond: 100}, strings.Repeat("a", 20))
+
+	start := time.Now()
+	newApiTest(conf, "http://test-backend", true).
+		Get("/api/users/info").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(strings.Repeat("a", 20)).
+		End()
+
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+}
+
+func TestProxy_Chaos_Truncate(t *testing.T) {
+	server := newTestServer(chaosMockConfig(&domain.Chaos{TruncateRate: 1}, strings.Repeat("a", 100)))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/api/users/info")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Error(t, err)
+	assert.Len(t, body, 50)
+}
+
+func TestProxy_Chaos_Reset(t *testing.T) {
+	server := newTestServer(chaosMockConfig(&domain.Chaos{ResetRate: 1}, `{"name": "jon"}`))
+	defer server.Close()
+
+	_, err := http.Get(server.URL + "/api/users/info")
+	assert.Error(t, err)
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+// rewriteSetCookies rewrites every Set-Cookie header of a backend response
+func rewriteSetCookies(cookies *domain.Cookies, h http.Header) {
+	lines := h.Values("Set-Cookie")
+	if len(lines) == 0 {
+		return
+	}
+	rewritten := make([]string, len(lines))
+	for i, line := range lines {
+		rewritten[i] = rewriteSetCookie(cookies, line)
+	}
+	h["Set-Cookie"] = rewritten
+}
+
+// rewriteSetCookie renames a Set-Cookie header value's cookie and replaces its attributes, keeping any
+// attributes that aren't configured as the backend set them
+func rewriteSetCookie(cookies *domain.Cookies, line string) string {
+	parts := strings.Split(line, ";")
+	nameValue := strings.TrimSpace(parts[0])
+	if i := strings.Index(nameValue, "="); i > 0 {
+		if to, ok := cookies.Rename[strings.TrimSpace(nameValue[:i])]; ok {
+			nameValue = to + nameValue[i:]
+		}
+	}
+
+	attrs := []string{nameValue}
+	hasDomain := false
+	for _, attr := range parts[1:] {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(strings.SplitN(attr, "=", 2)[0])) {
+		case "domain":
+			hasDomain = true
+			if cookies.Domain != nil {
+				continue
+			}
+		case "path":
+			if cookies.Path != nil {
+				continue
+			}
+		case "secure":
+			if cookies.Secure != nil {
+				continue
+			}
+		case "samesite":
+			if cookies.SameSite != nil {
+				continue
+			}
+		}
+		attrs = append(attrs, attr)
+	}
+
+	if cookies.Domain != nil && *cookies.Domain != "" && hasDomain {
+		attrs = append(attrs, "Domain="+*cookies.Domain)
+	}
+	if cookies.Path != nil && *cookies.Path != "" {
+		attrs = append(attrs, "Path="+*cookies.Path)
+	}
+	if cookies.Secure != nil && *cookies.Secure {
+		attrs = append(attrs, "Secure")
+	}
+	if cookies.SameSite != nil && *cookies.SameSite != "" {
+		sameSite := strings.ToLower(*cookies.SameSite)
+		attrs = append(attrs, "SameSite="+strings.ToUpper(sameSite[:1])+sameSite[1:])
+	}
+	return strings.Join(attrs, "; ")
+}
+
+// renameRequestCookies renames the cookies sent by the browser back to the names the backend set them with
+func renameRequestCookies(req *http.Request, rename map[string]string) {
+	if len(rename) == 0 {
+		return
+	}
+	backendNames := make(map[string]string, len(rename))
+	for backendName, name := range rename {
+		backendNames[name] = backendName
+	}
+
+	for i, line := range req.Header.Values("Cookie") {
+		pairs := strings.Split(line, ";")
+		for j, pair := range pairs {
+			pair = strings.TrimSpace(pair)
+			if k := strings.Index(pair, "="); k > 0 {
+				if backendName, ok := backendNames[pair[:k]]; ok {
+					pair = backendName + pair[k:]
+				}
+			}
+			pairs[j] = pair
+		}
+		req.Header["Cookie"][i] = strings.Join(pairs, "; ")
+	}
+}
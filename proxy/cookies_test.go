@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteSetCookie(t *testing.T) {
+	empty, root, lax := "", "/", "LAX"
+	insecure := false
+	tests := map[string]struct {
+		cookies  domain.Cookies
+		expected string
+	}{
+		"unchanged": {
+			domain.Cookies{},
+			"session=abc; Domain=.example.com; Path=/; Secure; SameSite=None; HttpOnly",
+		},
+		"localhost": {
+			domain.Cookies{Domain: &empty, Secure: &insecure, SameSite: &lax},
+			"session=abc; Path=/; HttpOnly; SameSite=Lax",
+		},
+		"renamed to a path": {
+			domain.Cookies{Path: &root, Rename: map[string]string{"session": "backend_session"}},
+			"backend_session=abc; Domain=.example.com; Secure; SameSite=None; HttpOnly; Path=/",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual := rewriteSetCookie(&test.cookies, "session=abc; Domain=.example.com; Path=/; Secure; SameSite=None; HttpOnly")
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+
+	// cookies without a domain are already set on the proxy's host
+	local := "localhost"
+	assert.Equal(t, "id=1; Path=/", rewriteSetCookie(&domain.Cookies{Domain: &local}, "id=1; Path=/"))
+	assert.Equal(t, "id=1; Domain=localhost", rewriteSetCookie(&domain.Cookies{Domain: &local}, "id=1; domain=example.com"))
+}
+
+func TestProxy_Cookies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "JSESSIONID=abc; Domain=.sainsburys.co.uk; Path=/; Secure; SameSite=None")
+		w.Header().Add("Set-Cookie", "basket=1; Path=/basket")
+		_, _ = w.Write([]byte(r.Header.Get("Cookie")))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	empty := ""
+	insecure := false
+	lax := "lax"
+	p := newTestProxy(configWithRoutes(domain.Route{
+		Type:        domain.RouteTypeProxy,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/api/.*")},
+		Backend:     &domain.Backend{URL: backendURL},
+		Cookies: &domain.Cookies{
+			Domain:   &empty,
+			Secure:   &insecure,
+			SameSite: &lax,
+			Rename:   map[string]string{"JSESSIONID": "test_session"},
+		},
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/api/account", nil)
+	req.Header.Set("Cookie", "test_session=abc; basket=1; other=2")
+	w := httptest.NewRecorder()
+	p.server.Handler.ServeHTTP(w, req)
+
+	assert.Equal(t, "JSESSIONID=abc; basket=1; other=2", w.Body.String())
+	assert.Equal(t, []string{
+		"test_session=abc; Path=/; SameSite=Lax",
+		"basket=1; Path=/basket; SameSite=Lax",
+	}, w.Header().Values("Set-Cookie"))
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+// corsHeaders are the response headers set by a CORS policy, which replace any the backend or mock set
+var corsHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+}
+
+// isPreflight checks if the request is a CORS preflight request, which asks if the request it precedes is allowed
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsPolicy returns the route's CORS policy, or the config's if it doesn't have one
+func corsPolicy(conf domain.Config, route *domain.Route) *domain.CORS {
+	if route != nil && route.CORS != nil {
+		return route.CORS
+	}
+	return conf.CORS
+}
+
+// preflightPolicy returns the CORS policy for the request a preflight request precedes, matching it to a route
+// by the method it asks for rather than OPTIONS
+func preflightPolicy(conf domain.Config, routes []domain.Route, matcher domain.Matcher, r *http.Request, mocksEnabled bool) *domain.CORS {
+	preceding := r.Clone(r.Context())
+	preceding.Method = r.Header.Get("Access-Control-Request-Method")
+	_, route, _, err := matchRoute(routes, matcher, preceding, mocksEnabled)
+	if err != nil {
+		return conf.CORS
+	}
+	return corsPolicy(conf, route)
+}
+
+// writePreflight answers a preflight request, allowing it if the policy allows its origin
+func writePreflight(policy *domain.CORS, w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+	if !policy.AllowsOrigin(origin) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("CORS origin not allowed"))
+		return
+	}
+
+	setCORSHeaders(policy, r, w.Header())
+	methods := strings.Join(policy.AllowMethods, ", ")
+	if methods == "" {
+		methods = r.Header.Get("Access-Control-Request-Method")
+	}
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	headers := strings.Join(policy.AllowHeaders, ", ")
+	if headers == "" {
+		headers = r.Header.Get("Access-Control-Request-Headers")
+	}
+	if headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	if policy.MaxAge != nil {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setCORSHeaders replaces the CORS headers in h with the policy's, if the request is cross-origin and the
+// policy allows its origin
+func setCORSHeaders(policy *domain.CORS, r *http.Request, h http.Header) {
+	origin := r.Header.Get("Origin")
+	if policy == nil || origin == "" {
+		return
+	}
+	for _, name := range corsHeaders {
+		h.Del(name)
+	}
+	if !policy.AllowsOrigin(origin) {
+		return
+	}
+
+	if policy.AllowCredentials || !policy.AllowsAnyOrigin() {
+		h.Set("Access-Control-Allow-Origin", origin)
+		if !strings.Contains(strings.Join(h.Values("Vary"), ","), "Origin") {
+			h.Add("Vary", "Origin")
+		}
+	} else {
+		h.Set("Access-Control-Allow-Origin", "*")
+	}
+	if policy.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(policy.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposeHeaders, ", "))
+	}
+}
+
+// corsWriter sets the CORS headers of the policy just before the response headers are written, replacing
+// any the backend or a mock set, so the policy applies to every response including errors
+type corsWriter struct {
+	http.ResponseWriter
+	r      *http.Request
+	policy *domain.CORS
+	wrote  bool
+}
+
+func (w *corsWriter) WriteHeader(status int) {
+	if !w.wrote && status >= 200 {
+		w.wrote = true
+		setCORSHeaders(w.policy, w.r, w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *corsWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *corsWriter) Flush() {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *corsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxy_CORS(t *testing.T) {
+	backendRequests := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendRequests++
+		w.Header().Set("Access-Control-Allow-Origin", "https://www.example.com")
+		_, _ = w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	conf := configWithRoutes(
+		domain.Route{
+			Type:        domain.RouteTypeProxy,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/api/.*")},
+			Backend:     &domain.Backend{URL: backendURL},
+		},
+		domain.Route{
+			Type: domain.RouteTypeMock,
+			Mock: &domain.Mock{
+				MatchRequest: domain.MatchRequest{Method: http.MethodPost, Path: "^/basket$"},
+				Response: domain.Response{
+					Status:  http.StatusCreated,
+					Body:    "created",
+					Headers: map[string]domain.HeaderValues{"Access-Control-Allow-Origin": {"https://www.example.com"}},
+				},
+			},
+			CORS: &domain.CORS{
+				AllowOrigins:     []string{"http://localhost:*"},
+				AllowCredentials: true,
+				ExposeHeaders:    []string{"X-Basket-Id"},
+			},
+		},
+		domain.Route{
+			Type:        domain.RouteTypeRedirect,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/old$")},
+			Redirect:    &domain.Redirect{To: "/new", Type: "temporary"},
+		},
+		domain.Route{
+			Type:        domain.RouteTypeProxy,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/flaky$")},
+			Backend:     &domain.Backend{URL: backendURL},
+			Chaos:       &domain.Chaos{ErrorRate: 1, ErrorStatus: http.StatusServiceUnavailable},
+		},
+		domain.Route{
+			Type:        domain.RouteTypeProxy,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/down$")},
+			Backend:     &domain.Backend{URL: &url.URL{Scheme: "http", Host: "127.0.0.1:1"}},
+		},
+	)
+	conf.CORS = &domain.CORS{
+		AllowMethods: []string{"GET", "POST"},
+		MaxAge:       &domain.Duration{Duration: 10 * time.Minute},
+	}
+	p := newTestProxy(conf)
+
+	serve := func(method string, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost"+path, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		p.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("preflight with the global policy", func(t *testing.T) {
+		w := serve(http.MethodOptions, "/api/users", map[string]string{
+			"Origin":                         "http://localhost:3000",
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "content-type",
+		})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "content-type", w.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+		assert.Equal(t, 0, backendRequests)
+	})
+
+	t.Run("preflight for a mock, with the route's policy", func(t *testing.T) {
+		w := serve(http.MethodOptions, "/basket", map[string]string{
+			"Origin":                        "http://localhost:3000",
+			"Access-Control-Request-Method": "POST",
+		})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "POST", w.Header().Get("Access-Control-Allow-Methods"))
+
+		w = serve(http.MethodOptions, "/basket", map[string]string{
+			"Origin":                        "https://evil.example.com",
+			"Access-Control-Request-Method": "POST",
+		})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("mocked response, replacing the mock's headers", func(t *testing.T) {
+		w := serve(http.MethodPost, "/basket", map[string]string{"Origin": "http://localhost:3000"})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "X-Basket-Id", w.Header().Get("Access-Control-Expose-Headers"))
+		assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	})
+
+	t.Run("proxied response replaces the backend's headers", func(t *testing.T) {
+		w := serve(http.MethodGet, "/api/users", map[string]string{"Origin": "http://localhost:3000"})
+		assert.Equal(t, "backend", w.Body.String())
+		assert.Equal(t, []string{"*"}, w.Header().Values("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		w := serve(http.MethodGet, "/old", map[string]string{"Origin": "http://localhost:3000"})
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("error responses", func(t *testing.T) {
+		for path, status := range map[string]int{"/flaky": http.StatusServiceUnavailable, "/down": http.StatusBadGateway} {
+			w := serve(http.MethodGet, path, map[string]string{"Origin": "http://localhost:3000"})
+			assert.Equal(t, status, w.Code)
+			assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+
+	t.Run("same origin request", func(t *testing.T) {
+		w := serve(http.MethodGet, "/api/users", nil)
+		assert.Equal(t, "https://www.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
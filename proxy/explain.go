@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+// Explanation describes how the proxy would handle a request, without handling it
+type Explanation struct {
+	// Server is the name of the server whose routes were used, if it wasn't the top level routes
+	Server string
+	// Routes explains each route that was tried, up to and including the one that matched
+	Routes []RouteExplanation
+	// RouteIndex is the index of the matched route, or -1 if no route matched
+	RouteIndex int
+	Route      *domain.Route
+	// Action is how the request would be handled: default, proxy, redirect or mock
+	Action string
+	// Backend is the URL the request would be proxied to, after any rewrite
+	Backend string
+	// RedirectTo is the location of a redirect
+	RedirectTo string
+	// Status is the status of a redirect or mock response
+	Status int
+	// Error is why the proxy would fail to handle the request
+	Error string
+}
+
+// Explain works out how a request to the port would be handled with conf, using the same route matching
+// as the proxy. Mock scenarios are all in their started state
+func Explain(conf domain.Config, defaultBackend *url.URL, port int, r *http.Request, mocksEnabled bool) Explanation {
+	e := Explanation{RouteIndex: -1}
+	matcher := domain.NewMatcher()
+
+	routes, server, r := routesFor(conf, port, r)
+	e.Server = serverName(server)
+
+	index, route, explanations, err := matchRoute(routes, matcher, r, mocksEnabled)
+	e.Routes = explanations
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	e.RouteIndex = index
+	e.Route = route
+
+	direct := director(defaultBackend, log.New(ioutil.Discard, "", 0))
+	switch {
+	case route == nil:
+		e.Action = actionDefault
+		req := r.Clone(r.Context())
+		direct(req)
+		e.Backend = req.URL.String()
+	case route.Type == domain.RouteTypeProxy:
+		e.Action = actionProxy
+		req := r.Clone(context.WithValue(r.Context(), routeCtxKey, route))
+		direct(req)
+		e.Backend = req.URL.String()
+	case route.Type == domain.RouteTypeRedirect:
+		to := replaceURL(route.PathPattern, route.Redirect.To, r.URL)
+		u, err := url.Parse(to)
+		if err != nil {
+			e.Error = err.Error()
+			return e
+		}
+		e.Action = actionRedirect
+		e.RedirectTo = u.String()
+		e.Status = redirectStatusCode(route.Redirect.Type)
+	case route.Type == domain.RouteTypeMock:
+		e.Action = actionMock
+		e.Status = route.Mock.Response.Status
+		if route.Mock.Response.WebSocket != nil {
+			e.Status = http.StatusSwitchingProtocols
+		}
+	}
+	return e
+}
+
+// RouteExplanation describes whether a route matched a request, and why not if it didn't
+type RouteExplanation struct {
+	Index int    `json:"index"`
+	Type  string `json:"type"`
+	Name  string `json:"name,omitempty"`
+	// Origin is the included file the route was declared in, and its path in that file
+	Origin  string   `json:"origin,omitempty"`
+	Matched bool     `json:"matched"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func newRouteExplanation(index int, route domain.Route, reasons []string) RouteExplanation {
+	explanation := RouteExplanation{
+		Index:   index,
+		Type:    route.Type,
+		Name:    route.Name,
+		Matched: len(reasons) == 0,
+		Reasons: reasons,
+	}
+	if route.Origin != nil {
+		explanation.Origin = route.Origin.File + " " + route.Origin.Path
+	}
+	return explanation
+}
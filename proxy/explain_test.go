@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain_Proxy(t *testing.T) {
+	backend, _ := url.Parse("http://localhost:3001")
+	conf := configWithRoutes(domain.Route{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Method: http.MethodPost, Path: "^/test-ui/.*"},
+			Response:     domain.Response{Status: http.StatusCreated},
+		},
+	}, domain.Route{
+		Name:        "users",
+		Type:        domain.RouteTypeProxy,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/test-ui/.*")},
+		Backend:     &domain.Backend{URL: backend},
+		Rewrite: []domain.Rewrite{{
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/test-ui/(.*)")},
+			To:          "/$1",
+		}},
+	})
+	defaultBackend, _ := url.Parse("http://test-backend")
+
+	r := httptest.NewRequest(http.MethodGet, "/test-ui/users/info?x=1", nil)
+	e := Explain(conf, defaultBackend, 8080, r, true)
+
+	assert.Empty(t, e.Error)
+	assert.Equal(t, 1, e.RouteIndex)
+	assert.Equal(t, actionProxy, e.Action)
+	assert.Equal(t, "http://localhost:3001/users/info?x=1", e.Backend)
+	require.Len(t, e.Routes, 2)
+	assert.Equal(t, []string{"method GET isn't POST"}, e.Routes[0].Reasons)
+	assert.True(t, e.Routes[1].Matched)
+	// the request itself isn't changed
+	assert.Equal(t, "/test-ui/users/info", r.URL.Path)
+}
+
+func TestExplain_Redirect(t *testing.T) {
+	conf := configWithRoutes(domain.Route{
+		Type:        domain.RouteTypeRedirect,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/old-ui/(.*)")},
+		Redirect:    &domain.Redirect{To: "/new-ui/$1", Type: "temporary"},
+	})
+	defaultBackend, _ := url.Parse("http://test-backend")
+
+	e := Explain(conf, defaultBackend, 8080, httptest.NewRequest(http.MethodGet, "/old-ui/page?a=b", nil), false)
+
+	assert.Equal(t, actionRedirect, e.Action)
+	assert.Equal(t, "/new-ui/page?a=b", e.RedirectTo)
+	assert.Equal(t, http.StatusFound, e.Status)
+}
+
+func TestExplain_ServerDefaultBackend(t *testing.T) {
+	serverBackend, _ := url.Parse("https://account.example.com")
+	conf := config()
+	conf.Servers = []domain.Server{{
+		Name:           "account",
+		Port:           8080,
+		Hosts:          []string{"account.example.com"},
+		DefaultBackend: &domain.Backend{URL: serverBackend},
+	}}
+	defaultBackend, _ := url.Parse("http://test-backend")
+
+	r := httptest.NewRequest(http.MethodGet, "/test-ui/users", nil)
+	r.Host = "account.example.com"
+	e := Explain(conf, defaultBackend, 8080, r, false)
+
+	assert.Equal(t, "account", e.Server)
+	assert.Equal(t, -1, e.RouteIndex)
+	assert.Empty(t, e.Routes)
+	assert.Equal(t, actionDefault, e.Action)
+	assert.Equal(t, "https://account.example.com/test-ui/users", e.Backend)
+}
+
+func TestExplain_MissingRouteConfig(t *testing.T) {
+	conf := configWithRoutes(domain.Route{
+		Type:        domain.RouteTypeProxy,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/api/.*")},
+	}, domain.Route{
+		Type:        domain.RouteTypeRedirect,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/old-ui/.*")},
+	}, domain.Route{
+		Type:        domain.RouteTypeProxy,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/old-ui/.*")},
+	})
+	defaultBackend, _ := url.Parse("http://test-backend")
+
+	e := Explain(conf, defaultBackend, 8080, httptest.NewRequest(http.MethodGet, "/old-ui/page", nil), false)
+
+	// explaining stops where the proxy would, at the route it can't match
+	assert.Equal(t, "missing redirect in config", e.Error)
+	assert.Equal(t, -1, e.RouteIndex)
+	require.Len(t, e.Routes, 2)
+	assert.Equal(t, []string{"path '/old-ui/page' doesn't match path_pattern '^/api/.*'"}, e.Routes[0].Reasons)
+	assert.False(t, e.Routes[1].Matched)
+	assert.Equal(t, []string{"missing redirect in config"}, e.Routes[1].Reasons)
+}
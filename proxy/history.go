@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+const matchHistorySize = 100
+
+// RouteMatch records which route an inbound request matched
+type RouteMatch struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	URL    string    `json:"url"`
+	// Protocol is the protocol the request was received over, e.g. HTTP/2.0
+	Protocol string `json:"protocol"`
+	// Server is the name of the server the request was served by, or empty for the top level routes
+	Server string `json:"server,omitempty"`
+	// RouteIndex is the index of the matched route in the server's routes, or -1 for the default backend
+	RouteIndex int    `json:"route_index"`
+	RouteType  string `json:"route_type,omitempty"`
+}
+
+func newRouteMatch(r *http.Request, index int, route *domain.Route) RouteMatch {
+	m := RouteMatch{
+		Time:       time.Now(),
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Protocol:   r.Proto,
+		RouteIndex: index,
+	}
+	if route != nil {
+		m.RouteType = route.Type
+	}
+	return m
+}
+
+// matchHistory is a fixed size ring buffer of the most recent route matches
+type matchHistory struct {
+	mu      sync.Mutex
+	entries []RouteMatch
+	next    int
+}
+
+func newMatchHistory(size int) *matchHistory {
+	return &matchHistory{entries: make([]RouteMatch, 0, size)}
+}
+
+func (h *matchHistory) add(m RouteMatch) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) < cap(h.entries) {
+		h.entries = append(h.entries, m)
+		return
+	}
+	h.entries[h.next] = m
+	h.next = (h.next + 1) % len(h.entries)
+}
+
+// last returns up to n of the most recent matches, newest first
+func (h *matchHistory) last(n int) []RouteMatch {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n <= 0 || n > len(h.entries) {
+		n = len(h.entries)
+	}
+
+	matches := make([]RouteMatch, 0, n)
+	for i := 0; i < n; i++ {
+		idx := (h.next - 1 - i + 2*len(h.entries)) % len(h.entries)
+		matches = append(matches, h.entries[idx])
+	}
+	return matches
+}
+
+// Matches returns up to n of the most recent route matches, newest first
+func (p *Proxy) Matches(n int) []RouteMatch {
+	return p.history.last(n)
+}
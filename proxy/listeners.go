@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+const defaultBackendCtxKey = "default_backend"
+
+// localPort returns the port the request was received on, or 0 if it's unknown
+func localPort(r *http.Request) int {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+// listener is a port the proxy listens on, and the certificates to serve it over TLS with if any
+type listener struct {
+	port  int
+	certs []tls.Certificate
+	// auto issues certificates for host names that none of certs are for
+	auto bool
+}
+
+func (l listener) tls() bool {
+	return len(l.certs) > 0 || l.auto
+}
+
+// listeners returns every port to listen on, the proxy's own port first. Servers sharing a port share
+// a listener, with their certificates chosen by SNI
+func (p *Proxy) listeners() ([]listener, error) {
+	main := listener{port: p.port}
+	if p.TlsEnabled && p.TlsGetCertificate != nil {
+		main.auto = true
+	} else if p.TlsEnabled {
+		cert, err := tls.LoadX509KeyPair(p.TlsCertFile, p.TlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		main.certs = append(main.certs, cert)
+	}
+
+	byPort := map[int]*listener{p.port: &main}
+	for _, s := range p.Config().Servers {
+		l, ok := byPort[s.Port]
+		if !ok {
+			l = &listener{port: s.Port}
+			byPort[s.Port] = l
+		}
+		if s.TLS == nil {
+			continue
+		}
+		if s.TLS.Auto {
+			if p.TlsGetCertificate == nil {
+				return nil, fmt.Errorf("server '%s': tls auto requires a local CA", serverName(&s))
+			}
+			l.auto = true
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(s.TLS.CertFile, s.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("server '%s': %w", serverName(&s), err)
+		}
+		l.certs = append(l.certs, cert)
+	}
+
+	listeners := []listener{main}
+	for port, l := range byPort {
+		if port != p.port {
+			listeners = append(listeners, *l)
+		}
+	}
+	sort.Slice(listeners[1:], func(i, j int) bool {
+		return listeners[i+1].port < listeners[j+1].port
+	})
+	return listeners, nil
+}
+
+func (p *Proxy) serve(l listener) error {
+	server := p.httpServer(l)
+	if !l.tls() {
+		return server.ListenAndServe()
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// httpServer returns the server for a listener, which serves HTTP/2 as well as HTTP/1.1 over TLS, and
+// over plaintext when h2c is enabled
+func (p *Proxy) httpServer(l listener) *http.Server {
+	server := p.server
+	if l.port != p.port {
+		server = &http.Server{
+			Addr:     fmt.Sprintf(":%d", l.port),
+			Handler:  p.server.Handler,
+			ErrorLog: p.server.ErrorLog,
+		}
+	}
+
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	if l.tls() {
+		server.Protocols.SetHTTP2(true)
+		server.TLSConfig = p.tlsConfig(l)
+	} else if p.H2cEnabled {
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return server
+}
+
+func (p *Proxy) tlsConfig(l listener) *tls.Config {
+	config := &tls.Config{Certificates: l.certs}
+	if l.auto {
+		config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			// prefer a configured certificate for the host name
+			for i := range l.certs {
+				if hello.SupportsCertificate(&l.certs[i]) == nil {
+					return &l.certs[i], nil
+				}
+			}
+			return p.TlsGetCertificate(hello)
+		}
+	}
+	return config
+}
+
+// serverName names a server in logs and the match history, by its name if it has one or its port otherwise
+func serverName(s *domain.Server) string {
+	if s == nil {
+		return ""
+	}
+	if s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf(":%d", s.Port)
+}
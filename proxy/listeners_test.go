@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_Servers_VirtualHosts(t *testing.T) {
+	defaultBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("default backend"))
+	}))
+	defer defaultBackend.Close()
+	accountBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("account backend"))
+	}))
+	defer accountBackend.Close()
+
+	accountURL, _ := url.Parse(accountBackend.URL)
+	p := newTestProxy(domain.Config{}, withDefaultBackend(defaultBackend.URL))
+	server := httptest.NewServer(p.server.Handler)
+	defer server.Close()
+
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	p.SetConfig(domain.Config{
+		Servers: []domain.Server{
+			{
+				Name:  "shop",
+				Port:  port,
+				Hosts: []string{"shop.example.com"},
+				Routes: []domain.Route{{
+					Type: domain.RouteTypeMock,
+					Mock: &domain.Mock{
+						MatchRequest: domain.MatchRequest{Path: "^/basket$"},
+						Response:     domain.Response{Status: http.StatusOK, Body: "shop mock"},
+					},
+				}},
+			},
+			{
+				Name:           "account",
+				Port:           port,
+				Hosts:          []string{"*.account.example.com"},
+				DefaultBackend: &domain.Backend{URL: accountURL},
+			},
+		},
+	})
+
+	tests := map[string]string{
+		"shop.example.com":          "shop mock",
+		"www.account.example.com":   "account backend",
+		"www.elsewhere.example.com": "default backend",
+	}
+	for host, expected := range tests {
+		t.Run(host, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/basket", nil)
+			req.Host = host
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+
+			body, _ := ioutil.ReadAll(res.Body)
+			assert.Equal(t, expected, string(body))
+		})
+	}
+
+	var servers []string
+	for _, m := range p.Matches(3) {
+		servers = append(servers, m.Server)
+	}
+	assert.ElementsMatch(t, []string{"shop", "account", ""}, servers)
+}
+
+func TestProxy_Listeners(t *testing.T) {
+	p := newTestProxy(domain.Config{
+		Servers: []domain.Server{
+			{Port: 9000},
+			{Port: 8080, Hosts: []string{"shop.example.com"}},
+			{Port: 8443},
+		},
+	}, withMocksDisabled())
+
+	listeners, err := p.listeners()
+	require.NoError(t, err)
+
+	var ports []int
+	for _, l := range listeners {
+		ports = append(ports, l.port)
+	}
+	assert.Equal(t, []int{8080, 8443, 9000}, ports)
+}
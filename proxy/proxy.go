@@ -4,16 +4,19 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,10 +26,23 @@ import (
 const routeCtxKey = "route"
 
 type Proxy struct {
-	server      *http.Server
-	TlsEnabled  bool
-	TlsCertFile string
-	TlsKeyFile  string
+	server       *http.Server
+	reverseProxy *httputil.ReverseProxy
+	conf         *configStore
+	history      *matchHistory
+	traffic      *trafficLog
+	matcher      domain.Matcher
+	auth         *authenticator
+	transport    *backendTransport
+	port         int
+	TlsEnabled   bool
+	TlsCertFile  string
+	TlsKeyFile   string
+	// TlsGetCertificate issues certificates on demand when TlsEnabled, instead of using TlsCertFile and TlsKeyFile,
+	// and for servers with auto TLS
+	TlsGetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// H2cEnabled serves plaintext HTTP/2 with prior knowledge, as well as HTTP/1.1, on listeners without TLS
+	H2cEnabled bool
 }
 
 func NewProxy(
@@ -36,30 +52,49 @@ func NewProxy(
 	mocksEnabled bool,
 	logger *log.Logger,
 ) *Proxy {
+	transport := newBackendTransport()
 	reverseProxy := &httputil.ReverseProxy{
 		Director:       director(defaultBackend, logger),
 		ModifyResponse: modifyResponse(),
 		ErrorHandler:   errorHandler(logger),
+		Transport:      transport,
 	}
+	store := newConfigStore(conf, mocksEnabled)
+	history := newMatchHistory(matchHistorySize)
+	matcher := domain.NewMatcher()
+	auth := newAuthenticator(transport)
 	return &Proxy{
 		server: &http.Server{
 			Addr:    fmt.Sprintf(":%d", port),
-			Handler: handler(logger, reverseProxy, conf, domain.NewMatcher(), mocksEnabled),
+			Handler: handler(logger, reverseProxy, store, matcher, history, newRoller(), auth),
 		},
+		reverseProxy: reverseProxy,
+		conf:         store,
+		history:      history,
+		matcher:      matcher,
+		auth:         auth,
+		transport:    transport,
+		port:         port,
 	}
 }
 
+// Start listens on the proxy's port, and the port of every server in the config. Ports added by
+// reloading the config aren't listened on until the proxy is restarted
 func (p *Proxy) Start() {
-	if p.TlsEnabled {
-		err := p.server.ListenAndServeTLS(p.TlsCertFile, p.TlsKeyFile)
-		if err != nil {
-			panic(err)
-		}
-		return
+	listeners, err := p.listeners()
+	if err != nil {
+		panic(err)
 	}
 
-	err := p.server.ListenAndServe()
-	if err != nil {
+	for _, l := range listeners[1:] {
+		go func(l listener) {
+			if err := p.serve(l); err != nil {
+				panic(err)
+			}
+		}(l)
+	}
+
+	if err := p.serve(listeners[0]); err != nil {
 		panic(err)
 	}
 }
@@ -68,13 +103,22 @@ func director(defaultBackend *url.URL, logger *log.Logger) func(req *http.Reques
 	return func(req *http.Request) {
 		route, ok := req.Context().Value(routeCtxKey).(*domain.Route)
 		if !ok {
-			// if not route set, then direct to default backend
-			req.URL.Scheme = defaultBackend.Scheme
-			req.URL.Host = defaultBackend.Host
-			req.Host = defaultBackend.Host
+			// if not route set, then direct to default backend, or the server's if it has its own
+			backend := defaultBackend
+			if serverBackend, ok := req.Context().Value(defaultBackendCtxKey).(*url.URL); ok {
+				backend = serverBackend
+			}
+			req.URL.Scheme = backend.Scheme
+			req.URL.Host = backend.Host
+			req.Host = backend.Host
+			accessLogEntry(req).Backend = req.URL.String()
 			return
 		}
 
+		defer func() {
+			accessLogEntry(req).Backend = req.URL.String()
+		}()
+
 		// if route is set redirect to route backend
 		req.URL.Scheme = route.Backend.Scheme
 		req.URL.Host = route.Backend.Host
@@ -95,6 +139,15 @@ func director(defaultBackend *url.URL, logger *log.Logger) func(req *http.Reques
 		for name, value := range route.ProxyPassHeaders {
 			req.Header.Set(name, value)
 		}
+
+		if route.Cookies != nil {
+			renameRequestCookies(req, route.Cookies.Rename)
+		}
+
+		// set the route's auth, replacing any the client sent
+		if authorization, ok := req.Context().Value(authCtxKey).(string); ok {
+			req.Header.Set("Authorization", authorization)
+		}
 	}
 }
 
@@ -148,7 +201,11 @@ func modifyResponse() func(*http.Response) error {
 			res.Header.Set(k, v)
 		}
 
-		if len(route.ProxyResponseReplacements) != 0 {
+		if route.Cookies != nil {
+			rewriteSetCookies(route.Cookies, res.Header)
+		}
+
+		if len(route.ProxyResponseReplacements) != 0 && !isStreaming(res) {
 
 			bodyBytes, err := ioutil.ReadAll(res.Body)
 			if err != nil {
@@ -194,9 +251,20 @@ func modifyResponse() func(*http.Response) error {
 	}
 }
 
+// isStreaming checks if the response is a websocket, server-sent events or gRPC, which must be passed to
+// the client as it arrives rather than read in full
+func isStreaming(res *http.Response) bool {
+	if res.StatusCode == http.StatusSwitchingProtocols {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream" || strings.HasPrefix(mediaType, "application/grpc")
+}
+
 func errorHandler(logger *log.Logger) func(http.ResponseWriter, *http.Request, error) {
 	return func(w http.ResponseWriter, r *http.Request, err error) {
 		logger.Printf("%+v\n", err)
+		accessLogEntry(r).Error = err.Error()
 		w.WriteHeader(http.StatusBadGateway)
 		_, _ = w.Write([]byte("Bad gateway"))
 	}
@@ -205,22 +273,66 @@ func errorHandler(logger *log.Logger) func(http.ResponseWriter, *http.Request, e
 func handler(
 	logger *log.Logger,
 	reverseProxy *httputil.ReverseProxy,
-	conf domain.Config,
+	store *configStore,
 	matcher domain.Matcher,
-	mocksEnabled bool,
+	history *matchHistory,
+	roll roller,
+	auth *authenticator,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Printf("inbound request on '%s %s'\n", r.Method, r.URL.String())
+		logger.Printf("inbound request on '%s %s' over %s\n", r.Method, r.URL.String(), r.Proto)
+
+		// load the config once so the whole request is handled against the same config
+		conf := store.load()
+		mocksEnabled := store.mocksEnabled()
+
+		routes, server, r := routesFor(conf, localPort(r), r)
+		// CORS headers are set on every response, including errors, so the UI sees the real status
+		cw := &corsWriter{ResponseWriter: w, r: r, policy: conf.CORS}
+		w = cw
+		if server != nil {
+			logger.Printf("serving with server '%s'\n", serverName(server))
+		}
 
-		matchedRoute, err := matchRoute(conf, matcher, r, mocksEnabled)
+		entry := accessLogEntry(r)
+		index, matchedRoute, explanations, err := transitionRoute(routes, matcher, r, mocksEnabled)
+		explainTraffic(r, explanations)
 		if err != nil {
-			logger.Printf(err.Error())
+			entry.Error = err.Error()
+			logger.Println(err.Error())
 			w.WriteHeader(http.StatusBadGateway)
 			_, _ = w.Write([]byte("Bad gateway"))
+			return
+		}
+
+		match := newRouteMatch(r, index, matchedRoute)
+		match.Server = serverName(server)
+		history.add(match)
+		entry.setRoute(server, index, matchedRoute)
+
+		if isPreflight(r) {
+			if policy := preflightPolicy(conf, routes, matcher, r, mocksEnabled); policy != nil {
+				logger.Println("answering CORS preflight request")
+				entry.Action = actionPreflight
+				// the preflight response sets its own CORS headers
+				writePreflight(policy, cw.ResponseWriter, r)
+				return
+			}
+		}
+		cw.policy = corsPolicy(conf, matchedRoute)
+
+		chaos := conf.Chaos
+		if matchedRoute != nil && matchedRoute.Chaos != nil {
+			chaos = matchedRoute.Chaos
+		}
+		w, ok := applyChaos(chaos, w, r, roll, logger)
+		if !ok {
+			return
 		}
 
 		if matchedRoute == nil {
 			logger.Println("directing to default backend")
+			entry.Action = actionDefault
 			reverseProxy.ServeHTTP(w, r)
 			return
 		}
@@ -228,74 +340,223 @@ func handler(
 		switch matchedRoute.Type {
 		case domain.RouteTypeProxy:
 			logger.Printf("directing to route backend '%s'\n", matchedRoute.Backend.Host)
+			entry.Action = actionProxy
 			r = r.WithContext(context.WithValue(r.Context(), routeCtxKey, matchedRoute))
+			settings := conf.Transport.With(matchedRoute.Backend.Transport)
+			r = withTransport(r, settings)
+			if matchedRoute.Auth != nil {
+				authorization, err := auth.header(matchedRoute.Auth, settings)
+				if err != nil {
+					entry.Error = err.Error()
+					logger.Printf("failed to authenticate request. %v\n", err)
+					w.WriteHeader(http.StatusBadGateway)
+					_, _ = w.Write([]byte("Bad gateway"))
+					return
+				}
+				r = r.WithContext(context.WithValue(r.Context(), authCtxKey, authorization))
+			}
 			reverseProxy.ServeHTTP(w, r)
 		case domain.RouteTypeRedirect:
 			to := replaceURL(matchedRoute.PathPattern, matchedRoute.Redirect.To, r.URL)
 			u, err := url.Parse(to)
 			if err != nil {
-				logger.Printf(err.Error())
+				entry.Error = err.Error()
+				logger.Println(err.Error())
 				w.WriteHeader(http.StatusBadGateway)
 				_, _ = w.Write([]byte("Bad gateway"))
 				return
 			}
 
+			entry.Action = actionRedirect
+			entry.RedirectTo = u.String()
 			http.Redirect(w, r, u.String(), redirectStatusCode(matchedRoute.Redirect.Type))
 		case domain.RouteTypeMock:
 			if !mocksEnabled {
 				logger.Println("directing to default backend")
+				entry.Action = actionDefault
 				reverseProxy.ServeHTTP(w, r)
 				return
 			}
 			logger.Printf("directing to mock: %+v\n", matchedRoute.Mock.Response)
-			writeMockResponse(matchedRoute.Mock.Response, w)
+			entry.Action = actionMock
+			response, err := matchedRoute.Mock.Response.Render(r, matchedRoute.Mock.MatchRequest)
+			if err != nil {
+				entry.Error = err.Error()
+				logger.Printf("failed to render mock response. %v\n", err)
+				w.WriteHeader(http.StatusBadGateway)
+				_, _ = w.Write([]byte("Bad gateway"))
+				return
+			}
+			if response.WebSocket != nil {
+				serveWebSocketMock(response.WebSocket, w, r, logger)
+				return
+			}
+			writeMockResponse(response, w, r)
 		}
 	}
 }
 
-func matchRoute(conf domain.Config, matcher domain.Matcher, r *http.Request, mocksEnabled bool) (*domain.Route, error) {
-	for _, route := range conf.Routes {
-		switch route.Type {
-		case domain.RouteTypeProxy:
-			if route.PathPattern.MatchString(r.URL.Path) {
-				return &route, nil
-			}
-		case domain.RouteTypeRedirect:
-			if route.Redirect == nil {
-				return nil, errors.New("missing redirect in config")
-			}
-			if route.PathPattern.MatchString(r.URL.Path) {
-				return &route, nil
-			}
-		case domain.RouteTypeMock:
-			if mocksEnabled {
-				if route.Mock == nil {
-					return nil, errors.New("missing mock in config")
-				}
-				if matcher.Match(r, *route.Mock) {
-					return &route, nil
-				}
-			}
-		default:
-			return nil, fmt.Errorf("unknown route type '%s'", route.Type)
+// routesFor returns the routes to match a request to port against, and the server they belong to if they
+// aren't the top level routes. The returned request carries the default backend, if it's been overridden,
+// and the transport settings for it
+func routesFor(conf domain.Config, port int, r *http.Request) ([]domain.Route, *domain.Server, *http.Request) {
+	routes := conf.Routes
+	backend := conf.DefaultBackend
+	server := conf.ServerFor(port, r.Host)
+	if server != nil {
+		routes = server.Routes
+		if server.DefaultBackend != nil {
+			backend = server.DefaultBackend
 		}
 	}
-	return nil, nil
+	transport := conf.Transport
+	if backend != nil {
+		r = r.WithContext(context.WithValue(r.Context(), defaultBackendCtxKey, backend.URL))
+		transport = transport.With(backend.Transport)
+	}
+	return routes, server, withTransport(r, transport)
 }
 
-func writeMockResponse(response domain.Response, w http.ResponseWriter) {
-	body := []byte(response.Body)
+// matchRoute returns the first route matching the request, along with its index in routes and an
+// explanation of each route tried, up to and including the one that matched. If no route matches the
+// index is -1 and the route nil
+func matchRoute(routes []domain.Route, matcher domain.Matcher, r *http.Request, mocksEnabled bool) (int, *domain.Route, []RouteExplanation, error) {
+	var explanations []RouteExplanation
+	for i, route := range routes {
+		reasons, err := explainRoute(route, matcher, r, mocksEnabled)
+		if err != nil {
+			reasons = []string{err.Error()}
+		}
+		explanations = append(explanations, newRouteExplanation(i, route, reasons))
+		if err != nil {
+			return -1, nil, explanations, err
+		}
+		if len(reasons) == 0 {
+			return i, &route, explanations, nil
+		}
+	}
+	return -1, nil, explanations, nil
+}
 
-	if json.Valid(body) {
+// explainRoute returns why the route doesn't match the request, or nil if it matches. It returns an error
+// if the request matches a route that's missing config it needs
+func explainRoute(route domain.Route, matcher domain.Matcher, r *http.Request, mocksEnabled bool) ([]string, error) {
+	if route.Match != nil {
+		if reasons := matcher.ExplainRequest(r, *route.Match); len(reasons) > 0 {
+			return reasons, nil
+		}
+	}
+
+	switch route.Type {
+	case domain.RouteTypeProxy, domain.RouteTypeRedirect:
+		if route.Type == domain.RouteTypeRedirect && route.Redirect == nil {
+			return nil, errors.New("missing redirect in config")
+		}
+		if route.PathPattern == nil || route.PathPattern.Regexp == nil {
+			return nil, errors.New("missing path_pattern in config")
+		}
+		if !route.PathPattern.MatchString(r.URL.Path) {
+			return []string{fmt.Sprintf("path '%s' doesn't match path_pattern '%s'", r.URL.Path, route.PathPattern.String())}, nil
+		}
+		return nil, nil
+	case domain.RouteTypeMock:
+		if !mocksEnabled {
+			return []string{"mocks are disabled"}, nil
+		}
+		if route.Mock == nil {
+			return nil, errors.New("missing mock in config")
+		}
+		return matcher.Explain(r, *route.Mock), nil
+	default:
+		return nil, fmt.Errorf("unknown route type '%s'", route.Type)
+	}
+}
+
+// transitionRoute matches the request like matchRoute, and moves the scenario of a matched mock to its
+// new state. If another request moved the scenario on between matching and transitioning, the request
+// is matched again against the scenario's new state
+func transitionRoute(routes []domain.Route, matcher domain.Matcher, r *http.Request, mocksEnabled bool) (int, *domain.Route, []RouteExplanation, error) {
+	for {
+		index, route, explanations, err := matchRoute(routes, matcher, r, mocksEnabled)
+		if err != nil || route == nil || route.Type != domain.RouteTypeMock {
+			return index, route, explanations, err
+		}
+		if matcher.Scenarios().Transition(*route.Mock) {
+			return index, route, explanations, nil
+		}
+	}
+}
+
+func writeMockResponse(response domain.Response, w http.ResponseWriter, r *http.Request) {
+	body := response.Body
+
+	if json.Valid([]byte(body)) {
 		w.Header().Set("Content-Type", "application/json")
 	}
 
+	if len(response.EncodedBodies) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if coding := acceptedEncoding(r, response.EncodedBodies); coding != "" {
+			body = response.EncodedBodies[coding]
+			w.Header().Set("Content-Encoding", coding)
+		}
+	}
+
+	if response.ContentType != "" {
+		w.Header().Set("Content-Type", response.ContentType)
+	}
+
+	for name, values := range response.Headers {
+		w.Header().Del(name)
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
 	for _, cookie := range response.Cookies {
 		addCookie(w, cookie)
 	}
 
+	// body files are served as content so that Range and conditional requests are supported. Only body files
+	// have a ContentType, and a templated body is different for each request
+	if response.Status == http.StatusOK && response.ContentType != "" && !response.Template {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+		return
+	}
+
+	if body != "" && w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
 	w.WriteHeader(response.Status)
-	_, _ = w.Write(body)
+	_, _ = w.Write([]byte(body))
+}
+
+// acceptedEncoding returns the preferred content coding that the request accepts and there is an encoded
+// body for, or an empty string if there isn't one
+func acceptedEncoding(r *http.Request, encoded map[string]string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		rejected := false
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				rejected = err == nil && q == 0
+			}
+		}
+		if coding != "" && !rejected {
+			accepted[coding] = true
+		}
+	}
+
+	for _, coding := range []string{"br", "gzip"} {
+		if _, ok := encoded[coding]; ok && accepted[coding] {
+			return coding
+		}
+	}
+	return ""
 }
 
 func addCookie(w http.ResponseWriter, cookie domain.Cookie) {
@@ -1,15 +1,20 @@
 package proxy
 
 import (
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"regexp"
+	"sync"
 	"testing"
 
 	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
 	"github.com/steinfletcher/apitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func newApiTest(
@@ -17,13 +22,78 @@ func newApiTest(
 	defaultBackend string,
 	mocksEnabled bool,
 ) *apitest.APITest {
-	u, err := url.Parse(defaultBackend)
+	opts := []testProxyOption{withDefaultBackend(defaultBackend)}
+	if !mocksEnabled {
+		opts = append(opts, withMocksDisabled())
+	}
+	p := newTestProxy(conf, opts...)
+	return apitest.New().Handler(p.server.Handler)
+}
+
+type testProxyOptions struct {
+	defaultBackend  string
+	mocksEnabled    bool
+	accessLog       io.Writer
+	accessLogFormat string
+	accessLogLevel  string
+}
+
+// testProxyOption changes the proxy newTestProxy creates
+type testProxyOption func(*testProxyOptions)
+
+// withDefaultBackend replaces the default backend, http://test-backend
+func withDefaultBackend(rawURL string) testProxyOption {
+	return func(o *testProxyOptions) {
+		o.defaultBackend = rawURL
+	}
+}
+
+func withMocksDisabled() testProxyOption {
+	return func(o *testProxyOptions) {
+		o.mocksEnabled = false
+	}
+}
+
+// withAccessLog writes the access log to w, in the format and from the level given
+func withAccessLog(w io.Writer, format string, level string) testProxyOption {
+	return func(o *testProxyOptions) {
+		o.accessLog = w
+		o.accessLogFormat = format
+		o.accessLogLevel = level
+	}
+}
+
+// newTestProxy creates a proxy on port 8080 with mocks enabled, that logs nowhere
+func newTestProxy(conf domain.Config, opts ...testProxyOption) *Proxy {
+	o := testProxyOptions{defaultBackend: "http://test-backend", mocksEnabled: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	u, err := url.Parse(o.defaultBackend)
 	if err != nil {
 		panic(err)
 	}
 	logger := log.New(ioutil.Discard, "", log.LstdFlags)
-	p := NewProxy(8080, conf, u, mocksEnabled, logger)
-	return apitest.New().Handler(p.server.Handler)
+	p := NewProxy(8080, conf, u, o.mocksEnabled, logger)
+
+	if o.accessLog != nil {
+		accessLog, err := NewAccessLog(o.accessLog, o.accessLogFormat, o.accessLogLevel)
+		if err != nil {
+			panic(err)
+		}
+		p.AccessLog(accessLog)
+	}
+	return p
+}
+
+// newTestServer serves a proxy created by newTestProxy on a random port, for tests that need a real connection
+func newTestServer(conf domain.Config, opts ...testProxyOption) *httptest.Server {
+	p := newTestProxy(conf, opts...)
+	server := httptest.NewUnstartedServer(p.server.Handler)
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", log.LstdFlags)
+	server.Start()
+	return server
 }
 
 func TestProxy_DefaultBackend_Success(t *testing.T) {
@@ -38,6 +108,25 @@ func TestProxy_DefaultBackend_Success(t *testing.T) {
 		End()
 }
 
+func TestProxy_DefaultBackend_FromProfile(t *testing.T) {
+	conf := config()
+	profileBackend, _ := url.Parse("http://preprod-backend")
+	conf.DefaultBackend = &domain.Backend{URL: profileBackend}
+
+	newApiTest(conf, "http://test-backend", false).
+		Mocks(apitest.NewMock().
+			Get("http://preprod-backend/original-ui/product").
+			RespondWith().
+			Status(http.StatusOK).
+			Body(`{"product_id": "123"}`).
+			End()).
+		Get("/original-ui/product").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"product_id": "123"}`).
+		End()
+}
+
 func TestProxy_ProxyBackend_OtherProxy_Success(t *testing.T) {
 	newApiTest(config(), "http://test-backend", false).
 		Mocks(
@@ -291,6 +380,317 @@ func TestProxy_MocksEnabled_MockBackend_Success(t *testing.T) {
 		End()
 }
 
+func TestProxy_MocksEnabled_Scenario(t *testing.T) {
+	conf := configWithRoutes(
+		domain.Route{
+			Type: "mock",
+			Mock: &domain.Mock{
+				MatchRequest: domain.MatchRequest{Method: "GET", Path: "^/api/order$"},
+				Response:     domain.Response{Status: http.StatusAccepted, Body: `{"status": "pending"}`},
+				Scenario:     "order",
+				State:        domain.ScenarioStateStarted,
+				NewState:     "pending",
+			},
+		},
+		domain.Route{
+			Type: "mock",
+			Mock: &domain.Mock{
+				MatchRequest: domain.MatchRequest{Method: "GET", Path: "^/api/order$"},
+				Response:     domain.Response{Status: http.StatusAccepted, Body: `{"status": "pending"}`},
+				Scenario:     "order",
+				State:        "pending",
+				NewState:     "done",
+			},
+		},
+		domain.Route{
+			Type: "mock",
+			Mock: &domain.Mock{
+				MatchRequest: domain.MatchRequest{Method: "GET", Path: "^/api/order$"},
+				Response:     domain.Response{Status: http.StatusOK, Body: `{"status": "done"}`},
+				Scenario:     "order",
+				State:        "done",
+			},
+		},
+	)
+	p := newTestProxy(conf)
+
+	for _, expected := range []struct {
+		status int
+		body   string
+	}{
+		{http.StatusAccepted, `{"status": "pending"}`},
+		{http.StatusAccepted, `{"status": "pending"}`},
+		{http.StatusOK, `{"status": "done"}`},
+		{http.StatusOK, `{"status": "done"}`},
+	} {
+		apitest.New().Handler(p.server.Handler).
+			Get("/api/order").
+			Expect(t).
+			Status(expected.status).
+			Body(expected.body).
+			End()
+	}
+
+	p.Scenarios().ResetAll()
+
+	apitest.New().Handler(p.server.Handler).
+		Get("/api/order").
+		Expect(t).
+		Status(http.StatusAccepted).
+		End()
+}
+
+func TestProxy_MocksEnabled_ScenarioConcurrentRequests(t *testing.T) {
+	conf := configWithRoutes(
+		domain.Route{
+			Type: "mock",
+			Mock: &domain.Mock{
+				MatchRequest: domain.MatchRequest{Method: "POST", Path: "^/api/order$"},
+				Response:     domain.Response{Status: http.StatusCreated},
+				Scenario:     "order",
+				State:        domain.ScenarioStateStarted,
+				NewState:     "placed",
+			},
+		},
+		domain.Route{
+			Type: "mock",
+			Mock: &domain.Mock{
+				MatchRequest: domain.MatchRequest{Method: "POST", Path: "^/api/order$"},
+				Response:     domain.Response{Status: http.StatusConflict},
+				Scenario:     "order",
+				State:        "placed",
+			},
+		},
+	)
+	p := newTestProxy(conf)
+
+	const requests = 50
+	statuses := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			p.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/order", nil))
+			statuses <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(t, map[int]int{http.StatusCreated: 1, http.StatusConflict: requests - 1}, counts)
+	assert.Equal(t, "placed", p.Scenarios().State("order"))
+}
+
+func TestProxy_MocksEnabled_TemplatedResponse(t *testing.T) {
+	conf := configWithRoutes(domain.Route{
+		Type: "mock",
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Method: "GET", Path: `^/api/v1/product/(\d+)`},
+			Response: domain.Response{
+				Status:   http.StatusOK,
+				Body:     `{"product_id": "{{index .PathGroups 1}}"}`,
+				Template: true,
+			},
+		},
+	})
+
+	newApiTest(conf, "http://test-backend", true).
+		Get("/api/v1/product/123").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"product_id": "123"}`).
+		End()
+}
+
+func TestProxy_MocksEnabled_ResponseHeaders(t *testing.T) {
+	tests := map[string]struct {
+		response            domain.Response
+		expectedContentType string
+	}{
+		"json body": {
+			response:            domain.Response{Status: http.StatusOK, Body: `{"name": "jon"}`},
+			expectedContentType: "application/json",
+		},
+		"content type from body file": {
+			response:            domain.Response{Status: http.StatusOK, Body: `<p>jon</p>`, ContentType: "text/html; charset=utf-8"},
+			expectedContentType: "text/html; charset=utf-8",
+		},
+		"content type header overrides detected type": {
+			response: domain.Response{
+				Status:  http.StatusOK,
+				Body:    `{"name": "jon"}`,
+				Headers: map[string]domain.HeaderValues{"Content-Type": {"application/vnd.api+json"}},
+			},
+			expectedContentType: "application/vnd.api+json",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			response := test.response
+			response.Headers = map[string]domain.HeaderValues{"X-Correlation-ID": {"123"}, "Link": {"</a>", "</b>"}}
+			for k, v := range test.response.Headers {
+				response.Headers[k] = v
+			}
+			conf := configWithRoutes(domain.Route{
+				Type: "mock",
+				Mock: &domain.Mock{
+					MatchRequest: domain.MatchRequest{Method: "GET", Path: "^/api/users/.*"},
+					Response:     response,
+				},
+			})
+
+			newApiTest(conf, "http://test-backend", true).
+				Get("/api/users/info").
+				Expect(t).
+				Status(http.StatusOK).
+				Header("Content-Type", test.expectedContentType).
+				Header("X-Correlation-ID", "123").
+				Assert(func(res *http.Response, req *http.Request) error {
+					assert.Equal(t, []string{"</a>", "</b>"}, res.Header["Link"])
+					return nil
+				}).
+				End()
+		})
+	}
+}
+
+func TestProxy_MocksEnabled_InlineBodyIgnoresRange(t *testing.T) {
+	conf := configWithRoutes(domain.Route{
+		Type: "mock",
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Method: "GET", Path: "^/api/status$"},
+			Response:     domain.Response{Status: http.StatusOK, Body: "all good"},
+		},
+	})
+
+	newApiTest(conf, "http://test-backend", true).
+		Get("/api/status").
+		Header("Range", "bytes=4-6").
+		Header("If-Modified-Since", "Mon, 01 Jun 2020 10:00:00 GMT").
+		Expect(t).
+		Status(http.StatusOK).
+		HeaderNotPresent("Accept-Ranges").
+		Body("all good").
+		End()
+}
+
+func TestProxy_MocksEnabled_FileBody(t *testing.T) {
+	conf := configWithRoutes(domain.Route{
+		Type: "mock",
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Method: "GET", Path: "^/files/report.csv$"},
+			Response: domain.Response{
+				Status:        http.StatusOK,
+				Body:          "a,b\n1,2\n",
+				ContentType:   "text/csv; charset=utf-8",
+				EncodedBodies: map[string]string{"gzip": "gzipped", "br": "brotli"},
+			},
+		},
+	})
+
+	newApiTest(conf, "http://test-backend", true).
+		Get("/files/report.csv").
+		Header("Range", "bytes=4-6").
+		Expect(t).
+		Status(http.StatusPartialContent).
+		Header("Content-Type", "text/csv; charset=utf-8").
+		Header("Content-Range", "bytes 4-6/8").
+		Body("1,2").
+		End()
+
+	newApiTest(conf, "http://test-backend", true).
+		Get("/files/report.csv").
+		Header("Accept-Encoding", "gzip, br;q=0").
+		Expect(t).
+		Status(http.StatusOK).
+		Header("Content-Encoding", "gzip").
+		Header("Vary", "Accept-Encoding").
+		Body("gzipped").
+		End()
+
+	newApiTest(conf, "http://test-backend", true).
+		Get("/files/report.csv").
+		Header("Accept-Encoding", "gzip, deflate, br").
+		Expect(t).
+		Status(http.StatusOK).
+		Header("Content-Encoding", "br").
+		Body("brotli").
+		End()
+
+	newApiTest(conf, "http://test-backend", true).
+		Get("/files/report.csv").
+		Expect(t).
+		Status(http.StatusOK).
+		HeaderNotPresent("Content-Encoding").
+		Body("a,b\n1,2\n").
+		End()
+}
+
+func TestProxy_RouteMatch(t *testing.T) {
+	feature := "new-checkout"
+	local, _ := url.Parse("http://localhost:3001")
+	shared, _ := url.Parse("http://localhost:3002")
+	conf := configWithRoutes(
+		domain.Route{
+			Type:        domain.RouteTypeProxy,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/checkout/.*")},
+			Backend:     &domain.Backend{URL: local},
+			Match: &domain.MatchRequest{
+				Host:    "^shop\\.example\\.com$",
+				Method:  http.MethodPost,
+				Headers: map[string]*string{"X-Feature": &feature},
+			},
+		},
+		domain.Route{
+			Type:        domain.RouteTypeProxy,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/checkout/.*")},
+			Backend:     &domain.Backend{URL: shared},
+		},
+	)
+
+	tests := map[string]struct {
+		host     string
+		method   string
+		feature  string
+		expected string
+	}{
+		"matches":                      {"shop.example.com:8080", http.MethodPost, feature, "http://localhost:3001/checkout/pay"},
+		"no match if host different":   {"other.example.com", http.MethodPost, feature, "http://localhost:3002/checkout/pay"},
+		"no match if method different": {"shop.example.com", http.MethodPut, feature, "http://localhost:3002/checkout/pay"},
+		"no match if header missing":   {"shop.example.com", http.MethodPost, "", "http://localhost:3002/checkout/pay"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			backendMock := apitest.NewMock()
+			backendMock.Get(test.expected)
+			backendMock.Method(test.method).
+				RespondWith().
+				Status(http.StatusOK).
+				End()
+
+			req := newApiTest(conf, "http://test-backend", false).
+				Mocks(backendMock).
+				Intercept(func(request *http.Request) {
+					request.Host = test.host
+				}).
+				Method(test.method).
+				URL("/checkout/pay")
+			if test.feature != "" {
+				req = req.Header("X-Feature", test.feature)
+			}
+			req.Expect(t).
+				Status(http.StatusOK).
+				End()
+		})
+	}
+}
+
 func TestProxy_InvalidRouteType_Failure(t *testing.T) {
 	newApiTest(invalidTypeConfig(), "http://test-backend", false).
 		Get("/api/users/info").
@@ -299,6 +699,149 @@ func TestProxy_InvalidRouteType_Failure(t *testing.T) {
 		End()
 }
 
+func TestProxy_SetConfig_SwapsRoutes(t *testing.T) {
+	p := newTestProxy(config(), withMocksDisabled())
+
+	apitest.New().Handler(p.server.Handler).
+		Mocks(otherProxyMock(http.StatusOK, `{"product_id": "123"}`)).
+		Get("/test-ui/product").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+
+	replaced := p.SetConfig(configWithRoutes(domain.Route{
+		Type:        "redirect",
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("/test-ui/(.*)")},
+		Redirect:    &domain.Redirect{To: "http://www.domain2.com/$1", Type: "temporary"},
+	}))
+	assert.False(t, replaced)
+
+	apitest.New().Handler(p.server.Handler).
+		Get("/test-ui/product").
+		Expect(t).
+		Status(http.StatusFound).
+		Header("Location", "http://www.domain2.com/product").
+		End()
+
+	require.NoError(t, p.UpdateConfig(func(conf domain.Config) (domain.Config, error) {
+		conf.Chaos = &domain.Chaos{ErrorRate: 0.5}
+		return conf, nil
+	}))
+	assert.True(t, p.SetConfig(config()), "expected the admin API's changes to be reported as replaced")
+	assert.False(t, p.SetConfig(config()))
+}
+
+func TestProxy_Record_ReplaysAsMocks(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", log.LstdFlags)
+	p := newTestProxy(config(), withMocksDisabled())
+
+	var recorded domain.Config
+	rec := NewRecorder("recorded.json", func(path string, conf domain.Config) error {
+		recorded = conf
+		return nil
+	}, logger)
+	p.Record(rec)
+
+	apitest.New().Handler(p.server.Handler).
+		Mocks(apitest.NewMock().Get("http://test-backend/original-ui/product").
+			Query("id", "1").
+			RespondWith().
+			Status(http.StatusCreated).
+			Header("X-Trace", "abc").
+			Cookie("SESSION", "xyz").
+			Body(`{"product_id": "1"}`).
+			End()).
+		Get("/original-ui/product").
+		Query("id", "1").
+		Expect(t).
+		Status(http.StatusCreated).
+		End()
+
+	// writes are batched, so nothing's written until they're flushed
+	assert.Len(t, recorded.Routes, 0)
+	require.NoError(t, rec.Flush())
+	assert.Len(t, recorded.Routes, 1)
+	mock := recorded.Routes[0].Mock
+	assert.Equal(t, domain.RouteTypeMock, recorded.Routes[0].Type)
+	assert.Equal(t, domain.MatchRequest{Method: "GET", Path: `^/original-ui/product$`, Query: "id=1"}, mock.MatchRequest)
+	assert.Equal(t, http.StatusCreated, mock.Response.Status)
+	assert.Equal(t, `{"product_id": "1"}`, mock.Response.Body)
+	assert.Equal(t, domain.HeaderValues{"abc"}, mock.Response.Headers["X-Trace"])
+	assert.Equal(t, []domain.Cookie{{Name: "SESSION", Value: "xyz"}}, mock.Response.Cookies)
+
+	newApiTest(recorded, "http://test-backend", true).
+		Get("/original-ui/product").
+		Query("id", "1").
+		Expect(t).
+		Status(http.StatusCreated).
+		Body(`{"product_id": "1"}`).
+		End()
+}
+
+func TestProxy_Record_KeepsUndecodedContentEncoding(t *testing.T) {
+	p := newTestProxy(config(), withMocksDisabled())
+
+	var recorded domain.Config
+	rec := NewRecorder("recorded.json", func(path string, conf domain.Config) error {
+		recorded = conf
+		return nil
+	}, log.New(ioutil.Discard, "", 0))
+	p.Record(rec)
+
+	apitest.New().Handler(p.server.Handler).
+		Mocks(apitest.NewMock().Get("http://test-backend/app.js").
+			Header("Accept-Encoding", "^gzip$").
+			RespondWith().
+			Status(http.StatusOK).
+			Header("Content-Encoding", "br").
+			Body("brotli bytes").
+			End()).
+		Get("/app.js").
+		Header("Accept-Encoding", "gzip, deflate, br").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+
+	// backends that send br anyway are recorded still encoded, so the mock must say so
+	require.NoError(t, rec.Flush())
+	require.Len(t, recorded.Routes, 1)
+	response := recorded.Routes[0].Mock.Response
+	assert.Equal(t, "brotli bytes", response.Body)
+	assert.Equal(t, domain.HeaderValues{"br"}, response.Headers["Content-Encoding"])
+
+	newApiTest(recorded, "http://test-backend", true).
+		Get("/app.js").
+		Expect(t).
+		Status(http.StatusOK).
+		Header("Content-Encoding", "br").
+		Body("brotli bytes").
+		End()
+}
+
+func TestProxy_Matches_RecordsRouteIndex(t *testing.T) {
+	p := newTestProxy(config(), withMocksDisabled())
+
+	apitest.New().Handler(p.server.Handler).
+		Mocks(otherProxyMock(http.StatusOK, `{"product_id": "123"}`)).
+		Get("/test-ui/product").
+		Expect(t).
+		End()
+
+	apitest.New().Handler(p.server.Handler).
+		Mocks(defaultBackendMock(http.StatusOK, `{"product_id": "123"}`)).
+		Get("/original-ui/product").
+		Expect(t).
+		End()
+
+	matches := p.Matches(2)
+	assert.Len(t, matches, 2)
+	assert.Equal(t, "/original-ui/product", matches[0].URL)
+	assert.Equal(t, -1, matches[0].RouteIndex)
+	assert.Equal(t, "/test-ui/product", matches[1].URL)
+	assert.Equal(t, 1, matches[1].RouteIndex)
+	assert.Equal(t, domain.RouteTypeProxy, matches[1].RouteType)
+}
+
 func mockBackendMock(status int, responseBody string) *apitest.Mock {
 	return apitest.NewMock().Get("http://test-backend/api/users/info").
 		RespondWith().
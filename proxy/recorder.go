@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+const recordingCtxKey = "recording"
+
+// recordWriteDelay batches the responses recorded in quick succession, such as a page load, into one write
+const recordWriteDelay = 500 * time.Millisecond
+
+// headers that describe the upstream connection or encoding rather than the response itself,
+// so make no sense in a mock
+var unrecordedHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Date":              true,
+	"Keep-Alive":        true,
+	"Set-Cookie":        true,
+	"Transfer-Encoding": true,
+}
+
+// Recorder captures proxied request/response pairs as mock routes, writing them out as config
+// that can be loaded to replay the session without the backends
+type Recorder struct {
+	path   string
+	writer domain.ConfigWriter
+	logger *log.Logger
+
+	mu             sync.Mutex
+	routes         []domain.Route
+	keys           map[string]int
+	writeScheduled bool
+
+	// writeMu stops writes overlapping, without holding up the responses being recorded
+	writeMu sync.Mutex
+}
+
+// recording is the inbound request as it was before the director rewrote it
+type recording struct {
+	method string
+	url    url.URL
+	// body is nil if the request had no body, or it was streamed
+	body *capturedBody
+}
+
+func NewRecorder(path string, writer domain.ConfigWriter, logger *log.Logger) *Recorder {
+	return &Recorder{
+		path:   path,
+		writer: writer,
+		logger: logger,
+		keys:   map[string]int{},
+	}
+}
+
+// Record starts capturing every response proxied to a backend with rec
+func (p *Proxy) Record(rec *Recorder) {
+	modify := p.reverseProxy.ModifyResponse
+	p.reverseProxy.ModifyResponse = func(res *http.Response) error {
+		if err := modify(res); err != nil {
+			return err
+		}
+		if err := rec.record(res); err != nil {
+			rec.logger.Printf("failed to record response: %v\n", err)
+		}
+		return nil
+	}
+
+	next := p.server.Handler
+	p.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured := &recording{method: r.Method, url: *r.URL}
+		// ask backends for gzip, the only coding the recorder decodes, rather than br
+		if r.Header.Get("Accept-Encoding") != "" {
+			if acceptedEncoding(r, map[string]string{"gzip": ""}) == "gzip" {
+				r.Header.Set("Accept-Encoding", "gzip")
+			} else {
+				r.Header.Del("Accept-Encoding")
+			}
+		}
+		if r.Body != nil && r.Body != http.NoBody && !isStreamingRequest(r) {
+			captured.body = newCapturedBody(r.Body)
+			r.Body = captured.body
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), recordingCtxKey, captured)))
+	})
+}
+
+func (rec *Recorder) record(res *http.Response) error {
+	captured, ok := res.Request.Context().Value(recordingCtxKey).(*recording)
+	if !ok || isStreaming(res) {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// only gzip is decoded, so a body in any other coding is recorded as it is, along with its coding
+	var undecoded string
+	switch coding := strings.ToLower(res.Header.Get("Content-Encoding")); coding {
+	case "", "identity":
+	case "gzip":
+		body, err = gUnzipData(body)
+		if err != nil {
+			return err
+		}
+	default:
+		undecoded = coding
+		rec.logger.Printf("response to '%s %s' is %s encoded, so it's recorded without decoding\n",
+			captured.method, captured.url.String(), coding)
+	}
+
+	var requestBody []byte
+	if captured.body != nil {
+		var truncated bool
+		requestBody, truncated = captured.body.captured()
+		if truncated {
+			rec.logger.Printf("request body of '%s %s' is larger than %d bytes, so the mock matches any body\n",
+				captured.method, captured.url.String(), capturedBodyLimit)
+			requestBody = nil
+		}
+	}
+
+	route := mockRoute(captured.method, captured.url, string(requestBody), res.StatusCode, res.Header, string(body))
+	if undecoded != "" {
+		if route.Mock.Response.Headers == nil {
+			route.Mock.Response.Headers = map[string]domain.HeaderValues{}
+		}
+		route.Mock.Response.Headers["Content-Encoding"] = domain.HeaderValues{undecoded}
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	// a repeated request replaces the earlier recording, as only the first matching mock is ever served
+	key := strings.Join([]string{captured.method, captured.url.Path, captured.url.RawQuery, string(requestBody)}, " ")
+	if i, ok := rec.keys[key]; ok {
+		rec.routes[i] = route
+	} else {
+		rec.keys[key] = len(rec.routes)
+		rec.routes = append(rec.routes, route)
+	}
+
+	rec.logger.Printf("recorded '%s %s' to %s\n", captured.method, captured.url.String(), rec.path)
+
+	if !rec.writeScheduled {
+		rec.writeScheduled = true
+		time.AfterFunc(recordWriteDelay, func() {
+			if err := rec.Flush(); err != nil {
+				rec.logger.Printf("failed to write recording: %v\n", err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush writes the recorded routes out now, rather than after the batch of responses being recorded
+func (rec *Recorder) Flush() error {
+	rec.writeMu.Lock()
+	defer rec.writeMu.Unlock()
+
+	rec.mu.Lock()
+	rec.writeScheduled = false
+	routes := append([]domain.Route(nil), rec.routes...)
+	rec.mu.Unlock()
+
+	return rec.writer(rec.path, domain.Config{Routes: routes})
+}
+
+// mockRoute returns a mock route that matches the request and responds with the response
+func mockRoute(method string, u url.URL, requestBody string, status int, header http.Header, body string) domain.Route {
+	return domain.Route{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{
+				Method: method,
+				Path:   "^" + regexp.QuoteMeta(u.Path) + "$",
+				Query:  u.RawQuery,
+				Body:   requestBody,
+			},
+			Response: domain.Response{
+				Status:  status,
+				Body:    body,
+				Headers: recordedHeaders(header),
+				Cookies: recordedCookies((&http.Response{Header: header}).Cookies()),
+			},
+		},
+	}
+}
+
+func recordedHeaders(header http.Header) map[string]domain.HeaderValues {
+	headers := map[string]domain.HeaderValues{}
+	for name, values := range header {
+		if unrecordedHeaders[name] {
+			continue
+		}
+		headers[name] = append(domain.HeaderValues(nil), values...)
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+func recordedCookies(cookies []*http.Cookie) []domain.Cookie {
+	var recorded []domain.Cookie
+	for _, c := range cookies {
+		recorded = append(recorded, domain.Cookie{
+			Name:   c.Name,
+			Value:  c.Value,
+			MaxAge: c.MaxAge,
+		})
+	}
+	return recorded
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+// configStore holds the active config, and whether mocks are enabled, so that both can be
+// changed while the proxy is serving. Requests load the config once, so in-flight requests
+// finish against the config they started with
+type configStore struct {
+	mu      sync.Mutex // serialises updates
+	conf    atomic.Value
+	enabled int32
+	// updated is true if the config has been changed by update since it was last stored
+	updated bool
+}
+
+func newConfigStore(conf domain.Config, mocksEnabled bool) *configStore {
+	s := &configStore{}
+	s.store(conf)
+	s.setMocksEnabled(mocksEnabled)
+	return s
+}
+
+func (s *configStore) load() domain.Config {
+	return s.conf.Load().(domain.Config)
+}
+
+// store replaces the config, returning whether that replaced changes made by update
+func (s *configStore) store(conf domain.Config) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conf.Store(conf)
+
+	updated := s.updated
+	s.updated = false
+	return updated
+}
+
+func (s *configStore) update(fn func(domain.Config) (domain.Config, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// copy the routes so fn can't modify the config in-flight requests are using
+	conf := s.load()
+	conf.Routes = append([]domain.Route(nil), conf.Routes...)
+
+	conf, err := fn(conf)
+	if err != nil {
+		return err
+	}
+
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	s.conf.Store(conf)
+	s.updated = true
+	return nil
+}
+
+func (s *configStore) mocksEnabled() bool {
+	return atomic.LoadInt32(&s.enabled) == 1
+}
+
+func (s *configStore) setMocksEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&s.enabled, v)
+}
+
+// Config returns the active config
+func (p *Proxy) Config() domain.Config {
+	return p.conf.load()
+}
+
+// SetConfig atomically swaps the active config. Requests already in flight finish against
+// the config they started with. It returns true if it replaced changes made with UpdateConfig, such as
+// routes and chaos changed through the admin API
+func (p *Proxy) SetConfig(conf domain.Config) bool {
+	updated := p.conf.store(conf)
+	// forget the transports and tokens for settings and auth that may no longer be configured
+	p.transport.reset()
+	p.auth.reset()
+	return updated
+}
+
+// UpdateConfig applies fn to a copy of the active config, and swaps in the result if it's valid
+func (p *Proxy) UpdateConfig(fn func(domain.Config) (domain.Config, error)) error {
+	return p.conf.update(fn)
+}
+
+// MocksEnabled reports whether mock routes are currently being matched
+func (p *Proxy) MocksEnabled() bool {
+	return p.conf.mocksEnabled()
+}
+
+// SetMocksEnabled turns matching of mock routes on or off
+func (p *Proxy) SetMocksEnabled(enabled bool) {
+	p.conf.setMocksEnabled(enabled)
+}
+
+// Scenarios returns the current state of the mock scenarios
+func (p *Proxy) Scenarios() *domain.Scenarios {
+	return p.matcher.Scenarios()
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+const (
+	trafficCtxKey = "traffic"
+	trafficSize   = 100
+	// larger bodies aren't captured, to bound the memory used by the captured traffic
+	capturedBodyLimit = 256 << 10
+)
+
+// Exchange is a captured request and response, with how the request was handled
+type Exchange struct {
+	ID int64 `json:"id"`
+	AccessLogEntry
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBody     string      `json:"request_body"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	// ResponseBody is decompressed if the response was gzipped
+	ResponseBody string `json:"response_body"`
+	// Notes explain why a body isn't shown, e.g. because it's binary
+	Notes []string `json:"notes,omitempty"`
+	// Routes explains why each route before the matched one didn't match
+	Routes []RouteExplanation `json:"routes"`
+}
+
+// MockRoute returns a mock route that responds to the request with the captured response
+func (e Exchange) MockRoute() domain.Route {
+	u, _ := url.Parse(e.URL)
+	if u == nil {
+		u = &url.URL{}
+	}
+	return mockRoute(e.Method, *u, e.RequestBody, e.Status, e.ResponseHeaders, e.ResponseBody)
+}
+
+// trafficLog holds the most recent exchanges, oldest first
+type trafficLog struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	lastID    int64
+}
+
+func (t *trafficLog) add(e Exchange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastID++
+	e.ID = t.lastID
+	t.exchanges = append(t.exchanges, e)
+	if len(t.exchanges) > trafficSize {
+		t.exchanges = append([]Exchange(nil), t.exchanges[len(t.exchanges)-trafficSize:]...)
+	}
+}
+
+// CaptureTraffic starts capturing the most recent requests and responses, for inspecting with Traffic
+func (p *Proxy) CaptureTraffic() {
+	t := &trafficLog{}
+	p.traffic = t
+
+	next := p.server.Handler
+	p.server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		var requestBody *capturedBody
+		if r.Body != nil && r.Body != http.NoBody && !isStreamingRequest(r) {
+			requestBody = newCapturedBody(r.Body)
+			r.Body = requestBody
+		}
+
+		r, entry := withAccessLogEntry(r)
+		exchange := &Exchange{RequestHeaders: r.Header.Clone()}
+		rw := &accessLogWriter{ResponseWriter: w, capture: &bytes.Buffer{}}
+
+		defer func() {
+			entry.complete(rw, start)
+			exchange.AccessLogEntry = *entry
+			exchange.ResponseHeaders = w.Header().Clone()
+
+			var note string
+			if isStreamingRequest(r) {
+				note = "request body is streamed so wasn't captured"
+			} else if requestBody != nil {
+				if entry.Action != actionProxy && entry.Action != actionDefault {
+					// the body wasn't forwarded, so may not have been read, e.g. by a mock that doesn't match bodies
+					requestBody.readRest()
+				}
+				body, truncated := requestBody.captured()
+				exchange.RequestBody, note = displayBody("request", body, r.Header, truncated)
+			}
+			if note != "" {
+				exchange.Notes = append(exchange.Notes, note)
+			}
+			truncated := rw.bytes > int64(rw.capture.Len())
+			exchange.ResponseBody, note = displayBody("response", rw.capture.Bytes(), w.Header(), truncated)
+			if note != "" {
+				exchange.Notes = append(exchange.Notes, note)
+			}
+
+			t.add(*exchange)
+		}()
+
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), trafficCtxKey, exchange)))
+	})
+}
+
+// capturedBody captures the first capturedBodyLimit bytes of a body as it's read, so the body is still
+// forwarded as it arrives rather than after it's been read in full
+type capturedBody struct {
+	io.ReadCloser
+
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	bytes int64
+}
+
+func newCapturedBody(body io.ReadCloser) *capturedBody {
+	return &capturedBody{ReadCloser: body}
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytes += int64(n)
+	if remaining := capturedBodyLimit - b.buf.Len(); remaining > 0 {
+		if n < remaining {
+			remaining = n
+		}
+		b.buf.Write(p[:remaining])
+	}
+	return n, err
+}
+
+// readRest reads the rest of the body, up to the capture limit
+func (b *capturedBody) readRest() {
+	b.mu.Lock()
+	remaining := int64(capturedBodyLimit - b.buf.Len() + 1)
+	b.mu.Unlock()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(b, remaining))
+}
+
+// captured returns the bytes read so far, up to capturedBodyLimit, and whether there were more than that
+func (b *capturedBody) captured() ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...), b.bytes > int64(b.buf.Len())
+}
+
+// isStreamingRequest checks if the request body is a stream, such as a gRPC call, which must be forwarded as it
+// arrives and may not end until the response does
+func isStreamingRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream" || strings.HasPrefix(mediaType, "application/grpc")
+}
+
+// displayBody decodes a captured body to show, or returns a note saying why it can't be shown
+func displayBody(kind string, body []byte, header http.Header, truncated bool) (string, string) {
+	if truncated || len(body) > capturedBodyLimit {
+		return "", fmt.Sprintf("%s body is larger than %d bytes so wasn't captured", kind, capturedBodyLimit)
+	}
+	if len(body) == 0 {
+		return "", ""
+	}
+
+	switch coding := strings.ToLower(header.Get("Content-Encoding")); coding {
+	case "", "identity":
+	case "gzip":
+		decoded, err := gUnzipData(body)
+		if err != nil {
+			return "", fmt.Sprintf("%s body couldn't be decompressed: %v", kind, err)
+		}
+		body = decoded
+	default:
+		return "", fmt.Sprintf("%s body is %s encoded so can't be shown", kind, coding)
+	}
+
+	if !utf8.Valid(body) {
+		return "", fmt.Sprintf("%s body is %d bytes of binary", kind, len(body))
+	}
+	return string(body), ""
+}
+
+// explainTraffic records why each route did or didn't match the request, if traffic is being captured
+func explainTraffic(r *http.Request, explanations []RouteExplanation) {
+	if e, ok := r.Context().Value(trafficCtxKey).(*Exchange); ok {
+		e.Routes = explanations
+	}
+}
+
+// Traffic returns the captured exchanges after the one with ID since, oldest first. It's empty unless
+// CaptureTraffic has been called
+func (p *Proxy) Traffic(since int64) []Exchange {
+	if p.traffic == nil {
+		return []Exchange{}
+	}
+	p.traffic.mu.Lock()
+	defer p.traffic.mu.Unlock()
+
+	exchanges := []Exchange{}
+	for _, e := range p.traffic.exchanges {
+		if e.ID > since {
+			exchanges = append(exchanges, e)
+		}
+	}
+	return exchanges
+}
+
+// TrafficExchange returns the captured exchange with the ID, if it's still held
+func (p *Proxy) TrafficExchange(id int64) (Exchange, bool) {
+	for _, e := range p.Traffic(id - 1) {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return Exchange{}, false
+}
+
+// ClearTraffic forgets every captured exchange
+func (p *Proxy) ClearTraffic() {
+	if p.traffic == nil {
+		return
+	}
+	p.traffic.mu.Lock()
+	defer p.traffic.mu.Unlock()
+	p.traffic.exchanges = nil
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/steinfletcher/apitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_CaptureTraffic(t *testing.T) {
+	backend, _ := url.Parse("http://localhost:3001")
+	conf := configWithRoutes(domain.Route{
+		Name: "orders",
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Method: http.MethodPost, Path: "^/api/orders$"},
+			Response:     domain.Response{Status: http.StatusCreated, Body: `{"id": 1}`},
+		},
+	}, domain.Route{
+		Type:        domain.RouteTypeProxy,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/test-ui/.*")},
+		Backend:     &domain.Backend{URL: backend},
+	})
+
+	p := newTestProxy(conf)
+	p.CaptureTraffic()
+
+	gzipped, err := gZipData([]byte(`{"user_id": "123"}`))
+	require.NoError(t, err)
+
+	apitest.New().
+		Handler(p.server.Handler).
+		Mocks(apitest.NewMock().
+			Get("http://test-backend/api/users").
+			RespondWith().
+			Status(http.StatusOK).
+			Header("Content-Encoding", "gzip").
+			Body(string(gzipped)).
+			End()).
+		Get("/api/users").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+
+	exchanges := p.Traffic(0)
+	require.Len(t, exchanges, 1)
+	e := exchanges[0]
+	assert.Equal(t, int64(1), e.ID)
+	assert.Equal(t, -1, e.RouteIndex)
+	assert.Equal(t, actionDefault, e.Action)
+	assert.Equal(t, `{"user_id": "123"}`, e.ResponseBody)
+	require.Len(t, e.Routes, 2)
+	assert.Equal(t, []string{"method GET isn't POST", "path '/api/users' doesn't match '^/api/orders$'"}, e.Routes[0].Reasons)
+	assert.Equal(t, []string{"path '/api/users' doesn't match path_pattern '^/test-ui/.*'"}, e.Routes[1].Reasons)
+
+	route := e.MockRoute()
+	assert.Equal(t, "^/api/users$", route.Mock.MatchRequest.Path)
+	assert.Equal(t, `{"user_id": "123"}`, route.Mock.Response.Body)
+	assert.Nil(t, route.Mock.Response.Headers["Content-Encoding"])
+
+	assert.Empty(t, p.Traffic(1))
+	p.ClearTraffic()
+	assert.Empty(t, p.Traffic(0))
+}
+
+func TestProxy_CaptureTraffic_MatchedRoute(t *testing.T) {
+	conf := configWithRoutes(domain.Route{
+		Name: "orders",
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Method: http.MethodPost, Path: "^/api/orders$"},
+			Response:     domain.Response{Status: http.StatusCreated, Body: `{"id": 1}`},
+		},
+	})
+
+	p := newTestProxy(conf)
+	p.CaptureTraffic()
+
+	apitest.New().
+		Handler(p.server.Handler).
+		Post("/api/orders").
+		Body(`{"sku": "123"}`).
+		Expect(t).
+		Status(http.StatusCreated).
+		End()
+
+	e, ok := p.TrafficExchange(1)
+	require.True(t, ok)
+	assert.Equal(t, `{"sku": "123"}`, e.RequestBody)
+	assert.Equal(t, `{"id": 1}`, e.ResponseBody)
+	assert.Equal(t, []RouteExplanation{{Index: 0, Type: domain.RouteTypeMock, Name: "orders", Matched: true}}, e.Routes)
+}
+
+func TestProxy_CaptureTraffic_StreamedRequest(t *testing.T) {
+	received := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first := make([]byte, 5)
+		_, _ = io.ReadFull(r.Body, first)
+		received <- string(first)
+		rest, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(append(first, rest...))
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(configWithRoutes(), withDefaultBackend(backend.URL))
+	p.CaptureTraffic()
+
+	body, client := io.Pipe()
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/stream", body)
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+		p.server.Handler.ServeHTTP(w, req)
+		done <- w
+	}()
+
+	// the backend gets the start of the body while the client is still sending the rest
+	_, _ = client.Write([]byte("hello"))
+	select {
+	case first := <-received:
+		assert.Equal(t, "hello", first)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request body wasn't forwarded until it ended")
+	}
+	_, _ = client.Write([]byte(" world"))
+	_ = client.Close()
+
+	assert.Equal(t, "hello world", (<-done).Body.String())
+	assert.Equal(t, "hello world", p.Traffic(0)[0].RequestBody)
+}
+
+func TestProxy_CaptureTraffic_LargeAndGrpcRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(configWithRoutes(), withDefaultBackend(backend.URL))
+	p.CaptureTraffic()
+
+	large := httptest.NewRequest(http.MethodPost, "http://localhost/upload", strings.NewReader(strings.Repeat("a", capturedBodyLimit+1)))
+	p.server.Handler.ServeHTTP(httptest.NewRecorder(), large)
+	grpc := httptest.NewRequest(http.MethodPost, "http://localhost/grpc.Service/Call", strings.NewReader("\x00\x00\x00\x00\x00"))
+	grpc.Header.Set("Content-Type", "application/grpc+proto")
+	p.server.Handler.ServeHTTP(httptest.NewRecorder(), grpc)
+
+	exchanges := p.Traffic(0)
+	require.Len(t, exchanges, 2)
+	assert.Empty(t, exchanges[0].RequestBody)
+	assert.Contains(t, exchanges[0].Notes, "request body is larger than 262144 bytes so wasn't captured")
+	assert.Empty(t, exchanges[1].RequestBody)
+	assert.Contains(t, exchanges[1].Notes, "request body is streamed so wasn't captured")
+}
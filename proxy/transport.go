@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+// schemeH2C is the backend URL scheme for plaintext HTTP/2 backends, e.g. h2c://localhost:50051
+const schemeH2C = "h2c"
+
+const transportCtxKey = "transport"
+
+// backendTransport sends requests to backends with http.DefaultTransport, which uses HTTP/2 when the backend
+// supports it over TLS. Backends with an h2c:// URL are sent plaintext HTTP/2 with prior knowledge.
+// Requests carrying transport settings are sent with a transport built from them, which is reused by
+// every request with the same settings until their certificate files change or the config is replaced
+type backendTransport struct {
+	h2c http.RoundTripper
+
+	mu         sync.Mutex
+	transports map[string]*builtTransport
+}
+
+// builtTransport is a transport built from settings, and the state of the certificate files it was built with
+type builtTransport struct {
+	*http.Transport
+	files string
+}
+
+func newBackendTransport() *backendTransport {
+	h2c := &http.Transport{Protocols: new(http.Protocols)}
+	h2c.Protocols.SetUnencryptedHTTP2(true)
+
+	return &backendTransport{h2c: h2c, transports: map[string]*builtTransport{}}
+}
+
+func (t *backendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	settings, _ := req.Context().Value(transportCtxKey).(*domain.Transport)
+
+	out := req
+	h2c := false
+	if req.URL.Scheme == schemeH2C {
+		out = req.Clone(req.Context())
+		out.URL.Scheme = "http"
+		// protocol upgrades such as websockets only exist in HTTP/1.1
+		h2c = out.Header.Get("Upgrade") == ""
+	}
+
+	if settings == nil {
+		if h2c {
+			return t.h2c.RoundTrip(out)
+		}
+		return http.DefaultTransport.RoundTrip(out)
+	}
+
+	transport, err := t.transport(settings, h2c)
+	if err != nil {
+		return nil, err
+	}
+	return transport.RoundTrip(out)
+}
+
+// transport returns the transport for the settings, building it the first time they're used, or again when
+// their certificate files have changed, e.g. because a certificate was renewed
+func (t *backendTransport) transport(settings *domain.Transport, h2c bool) (*http.Transport, error) {
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%t %s", h2c, b)
+	files := fileStamps(settings.CAFile, settings.CertFile, settings.KeyFile)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	built, ok := t.transports[key]
+	if ok && built.files == files {
+		return built.Transport, nil
+	}
+
+	transport, err := buildTransport(settings, h2c)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		built.CloseIdleConnections()
+	}
+	t.transports[key] = &builtTransport{Transport: transport, files: files}
+	return transport, nil
+}
+
+// reset forgets the transports built so far, closing their idle connections, so that those for settings that are
+// no longer configured don't keep connections open
+func (t *backendTransport) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, built := range t.transports {
+		built.CloseIdleConnections()
+	}
+	t.transports = map[string]*builtTransport{}
+}
+
+// fileStamps describes the modification time and size of the files, to tell when any of them have changed
+func fileStamps(names ...string) string {
+	var stamps []string
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			stamps = append(stamps, name+" missing")
+			continue
+		}
+		stamps = append(stamps, fmt.Sprintf("%s %d %d", name, info.ModTime().UnixNano(), info.Size()))
+	}
+	return strings.Join(stamps, ",")
+}
+
+// buildTransport builds a transport with the same defaults as http.DefaultTransport, and the settings applied
+func buildTransport(settings *domain.Transport, h2c bool) (*http.Transport, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if settings.DialTimeout != nil {
+		dialer.Timeout = settings.DialTimeout.Value()
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConnsPerHost:   settings.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       settings.MaxConnsPerHost,
+	}
+	if settings.TLSHandshakeTimeout != nil {
+		transport.TLSHandshakeTimeout = settings.TLSHandshakeTimeout.Value()
+	}
+	if settings.ResponseHeaderTimeout != nil {
+		transport.ResponseHeaderTimeout = settings.ResponseHeaderTimeout.Value()
+	}
+	if settings.IdleConnTimeout != nil {
+		transport.IdleConnTimeout = settings.IdleConnTimeout.Value()
+	}
+	if settings.MaxIdleConns != 0 {
+		transport.MaxIdleConns = settings.MaxIdleConns
+	}
+
+	if settings.Proxy != "" {
+		proxyURL, err := url.Parse(settings.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transport proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := transportTLSConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if h2c {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return transport, nil
+}
+
+// transportTLSConfig returns the TLS config for the settings, or nil if they don't change the defaults
+func transportTLSConfig(settings *domain.Transport) (*tls.Config, error) {
+	insecure := settings.InsecureSkipVerify != nil && *settings.InsecureSkipVerify
+	if settings.CAFile == "" && settings.CertFile == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if settings.CAFile != "" {
+		pem, err := ioutil.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca_file '%s'", settings.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if settings.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// withTransport returns the request carrying the transport settings to send it to its backend with.
+// Requests without any are sent with http.DefaultTransport
+func withTransport(r *http.Request, settings *domain.Transport) *http.Request {
+	if settings == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), transportCtxKey, settings))
+}
@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/certs"
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_H2C(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("backend received " + r.Proto))
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	backendURL, _ := url.Parse(backend.URL)
+	backendURL.Scheme = "h2c"
+	p := newTestProxy(configWithRoutes(domain.Route{
+		Type:        domain.RouteTypeProxy,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/grpc/.*")},
+		Backend:     &domain.Backend{URL: backendURL},
+	}))
+	p.H2cEnabled = true
+	addr := serveTestListener(t, p, listener{port: p.port})
+
+	client := &http.Client{Transport: &http.Transport{Protocols: new(http.Protocols)}}
+	client.Transport.(*http.Transport).Protocols.SetUnencryptedHTTP2(true)
+
+	res, err := client.Get(fmt.Sprintf("http://%s/grpc/service", addr))
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Equal(t, "HTTP/2.0", res.Proto)
+	assert.Equal(t, "backend received HTTP/2.0", string(body))
+	assert.Equal(t, "HTTP/2.0", p.Matches(1)[0].Protocol)
+}
+
+func TestProxy_HTTP2OverTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	authority, err := certs.LoadOrCreateAuthority(dir)
+	require.NoError(t, err)
+	caPEM, _ := ioutil.ReadFile(authority.CertFile)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	p := newTestProxy(configWithRoutes(domain.Route{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Path: "^/ping$"},
+			Response:     domain.Response{Status: http.StatusOK, Body: "pong"},
+		},
+	}))
+	p.TlsEnabled = true
+	p.TlsGetCertificate = authority.GetCertificate
+	addr := serveTestListener(t, p, listener{port: p.port, auto: true})
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: "local.example.com"},
+		ForceAttemptHTTP2: true,
+	}}
+	res, err := client.Get(fmt.Sprintf("https://%s/ping", addr))
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, "HTTP/2.0", res.Proto)
+}
+
+// serveTestListener serves the proxy's server for l on a random port, returning its address
+func serveTestListener(t *testing.T, p *Proxy, l listener) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := p.httpServer(l)
+	go func() {
+		if l.tls() {
+			_ = server.ServeTLS(ln, "", "")
+			return
+		}
+		_ = server.Serve(ln)
+	}()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return ln.Addr().String()
+}
+
+func TestProxy_GrpcWeb_Streamed(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc-web+proto")
+		_, _ = w.Write([]byte{0, 0, 0, 0, 1, 'a'})
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	u, _ := url.Parse(backend.URL)
+	server := newTestServer(configWithRoutes(domain.Route{
+		Type:                      domain.RouteTypeProxy,
+		PathPattern:               &domain.PathPattern{Regexp: regexp.MustCompile("^/grpc/.*")},
+		Backend:                   &domain.Backend{URL: u},
+		ProxyResponseReplacements: map[string]string{"a": "b"},
+	}))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/grpc/Basket/Watch", "application/grpc-web+proto", nil)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	frame := make(chan []byte)
+	go func() {
+		b := make([]byte, 6)
+		_, _ = io.ReadFull(res.Body, b)
+		frame <- b
+	}()
+
+	select {
+	case b := <-frame:
+		assert.Equal(t, []byte{0, 0, 0, 0, 1, 'a'}, b)
+	case <-time.After(2 * time.Second):
+		t.Fatal("gRPC-web frame was buffered")
+	}
+}
+
+func TestProxy_Transport(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte("backend received " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "backend.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}), 0644))
+
+	backendURL, _ := url.Parse(backend.URL)
+	route := func(pattern string, transport *domain.Transport) domain.Route {
+		return domain.Route{
+			Type:        domain.RouteTypeProxy,
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile(pattern)},
+			Backend:     &domain.Backend{URL: backendURL, Transport: transport},
+		}
+	}
+	timeout := domain.Duration{Duration: 50 * time.Millisecond}
+	conf := configWithRoutes(
+		route("^/untrusted$", nil),
+		route("^/trusted$", &domain.Transport{CAFile: caFile}),
+		route("^/slow$", &domain.Transport{CAFile: caFile, ResponseHeaderTimeout: &timeout}),
+	)
+	conf.DefaultBackend = &domain.Backend{URL: backendURL}
+	insecure := true
+	conf.Transport = &domain.Transport{InsecureSkipVerify: &insecure}
+	p := newTestProxy(conf)
+
+	tests := map[string]struct {
+		status int
+		body   string
+	}{
+		"/trusted": {http.StatusOK, "backend received /trusted"},
+		// the global transport skips verifying the certificate for both the route and the default backend
+		"/untrusted": {http.StatusOK, "backend received /untrusted"},
+		"/default":   {http.StatusOK, "backend received /default"},
+		"/slow":      {http.StatusBadGateway, "Bad gateway"},
+	}
+	for path, expected := range tests {
+		t.Run(path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			p.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+			assert.Equal(t, expected.status, w.Code)
+			assert.Equal(t, expected.body, w.Body.String())
+		})
+	}
+
+	// without the global transport the backend's certificate is only trusted by the route with the ca_file
+	conf.Transport = nil
+	p.SetConfig(conf)
+	w := httptest.NewRecorder()
+	p.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/untrusted", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	w = httptest.NewRecorder()
+	p.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/trusted", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestProxy_Transport_RereadsCertificateFiles(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("backend received " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	dir, err := ioutil.TempDir("", "ui-dev-proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	other, err := certs.LoadOrCreateAuthority(dir)
+	require.NoError(t, err)
+	caFile := other.CertFile
+
+	backendURL, _ := url.Parse(backend.URL)
+	conf := configWithRoutes(domain.Route{
+		Type:        domain.RouteTypeProxy,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/api$")},
+		Backend:     &domain.Backend{URL: backendURL, Transport: &domain.Transport{CAFile: caFile}},
+	})
+	p := newTestProxy(conf)
+	get := func() int {
+		w := httptest.NewRecorder()
+		p.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/api", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadGateway, get())
+
+	// the CA file is replaced with one that trusts the backend, without reloading the config
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	require.NoError(t, ioutil.WriteFile(caFile, caPEM, 0644))
+	assert.Equal(t, http.StatusOK, get())
+
+	p.SetConfig(conf)
+	assert.Empty(t, p.transport.transports)
+}
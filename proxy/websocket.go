@@ -0,0 +1,330 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+)
+
+// websocket opcodes, see RFC 6455 section 5.2
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+const (
+	wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// larger messages from clients are rejected, as mocks only need to match them
+	wsMaxMessageSize = 16 << 20
+	// how long to wait for the client to acknowledge a close before dropping the connection
+	wsCloseTimeout = time.Second
+)
+
+// wsConn is a minimal websocket connection, enough to mock a backend's side of a websocket
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// mask is set on the client side of a connection, as clients must mask the frames they send
+	mask bool
+
+	mu sync.Mutex
+}
+
+// isWebSocketUpgrade checks if the request asks to upgrade to a websocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	_, _ = h.Write([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// webSocketKey returns the key the client sent to open a websocket, checking the handshake is one the
+// mock can answer
+func webSocketKey(r *http.Request) (string, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return "", errors.New("missing Sec-WebSocket-Key header")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return "", fmt.Errorf("unsupported websocket version '%s'", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	return key, nil
+}
+
+// upgradeWebSocket hijacks the connection and completes the websocket handshake for the client's key,
+// sending any headers already set on w. On error the response has already been written, or the
+// connection closed, so nothing more should be written to w
+func upgradeWebSocket(w http.ResponseWriter, key string) (*wsConn, error) {
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// nothing was hijacked, so the response can still be written
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("Websocket upgrade not supported"))
+		return nil, err
+	}
+
+	header := w.Header().Clone()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", wsAcceptKey(key))
+	header.Del("Content-Type")
+
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	_ = header.Write(rw)
+	_, _ = rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// readMessage reads the next text or binary message, answering any pings and closes on the way.
+// It returns io.EOF once the connection is closed
+func (c *wsConn) readMessage() (int, []byte, error) {
+	var opcode int
+	var message []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+		case wsPong:
+		case wsClose:
+			_ = c.writeFrame(wsClose, payload)
+			return 0, nil, io.EOF
+		case wsText, wsBinary, wsContinuation:
+			if op != wsContinuation {
+				opcode = op
+			}
+			if len(message)+len(payload) > wsMaxMessageSize {
+				return 0, nil, errors.New("websocket message too large")
+			}
+			message = append(message, payload...)
+			if fin {
+				return opcode, message, nil
+			}
+		default:
+			return 0, nil, fmt.Errorf("unknown websocket opcode %d", op)
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (bool, int, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := int(head[0] & 0x0f)
+	masked := head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessageSize {
+		return false, 0, nil, errors.New("websocket frame too large")
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes a single unfragmented frame. It's safe to call while another goroutine is reading
+func (c *wsConn) writeFrame(opcode int, payload []byte) error {
+	frame := []byte{0x80 | byte(opcode), 0}
+	switch {
+	case len(payload) < 126:
+		frame[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		frame[1] = 126
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame[1] = 127
+		frame = append(frame, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+
+	if c.mask {
+		frame[1] |= 0x80
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		frame = append(frame, key[:]...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ key[i%4]
+		}
+		payload = masked
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(append(frame, payload...))
+	return err
+}
+
+// serveWebSocketMock upgrades the request and plays the mock's messages to the client, until they have
+// all been sent and either the mock or the client closes the websocket
+func serveWebSocketMock(ws *domain.WebSocket, w http.ResponseWriter, r *http.Request, logger *log.Logger) {
+	if !isWebSocketUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		w.WriteHeader(http.StatusUpgradeRequired)
+		_, _ = w.Write([]byte("Expected websocket upgrade"))
+		return
+	}
+
+	key, err := webSocketKey(r)
+	if err != nil {
+		logger.Printf("failed to upgrade to websocket. %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	c, err := upgradeWebSocket(w, key)
+	if err != nil {
+		logger.Printf("failed to upgrade to websocket. %v\n", err)
+		return
+	}
+	defer c.conn.Close()
+
+	received := make(chan string, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(received)
+		for {
+			_, message, err := c.readMessage()
+			if err != nil {
+				if err != io.EOF {
+					logger.Printf("websocket mock read failed. %v\n", err)
+				}
+				return
+			}
+			select {
+			case received <- string(message):
+			default:
+				// nothing is waiting for messages, so drop them rather than stop answering pings
+			}
+		}
+	}()
+
+	for i, m := range ws.Messages {
+		if m.WaitFor != "" {
+			// validated when the config is loaded
+			re := regexp.MustCompile(m.WaitFor)
+			if !waitForMessage(received, re) {
+				return
+			}
+		}
+
+		if delay := m.Delay.Value(); delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-done:
+				return
+			}
+		}
+
+		opcode := wsText
+		if m.Binary {
+			opcode = wsBinary
+		}
+		if err := c.writeFrame(opcode, []byte(m.Body)); err != nil {
+			logger.Printf("websocket mock write failed. %v\n", err)
+			return
+		}
+		logger.Printf("sent websocket mock message %d\n", i)
+	}
+
+	if ws.Close {
+		_ = c.writeFrame(wsClose, []byte{0x03, 0xe8})
+		select {
+		case <-done:
+		case <-time.After(wsCloseTimeout):
+		}
+		return
+	}
+
+	<-done
+}
+
+// waitForMessage reads received messages until one matches re, returning false if the connection closes first
+func waitForMessage(received <-chan string, re *regexp.Regexp) bool {
+	for message := range received {
+		if re.MatchString(message) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JSainsburyPLC/ui-dev-proxy/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_WebSocket_Proxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := webSocketKey(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c, err := upgradeWebSocket(w, key)
+		if err != nil {
+			return
+		}
+		defer c.conn.Close()
+		_, message, err := c.readMessage()
+		if err != nil {
+			return
+		}
+		reply := fmt.Sprintf("%s %s %s", r.URL.Path, r.Header.Get("X-Test"), message)
+		_ = c.writeFrame(wsText, []byte(reply))
+	}))
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	server := newTestServer(configWithRoutes(domain.Route{
+		Type:        domain.RouteTypeProxy,
+		PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/ws/.*")},
+		Backend:     &domain.Backend{URL: u},
+		Rewrite: []domain.Rewrite{{
+			PathPattern: &domain.PathPattern{Regexp: regexp.MustCompile("^/ws/(.*)")},
+			To:          "/$1",
+		}},
+		ProxyPassHeaders:          map[string]string{"X-Test": "passed"},
+		ProxyResponseReplacements: map[string]string{"a": "b"},
+	}))
+	defer server.Close()
+
+	c := dialWebSocket(t, server.URL+"/ws/updates")
+	defer c.conn.Close()
+
+	require.NoError(t, c.writeFrame(wsText, []byte("hello")))
+	opcode, message, err := c.readMessage()
+	require.NoError(t, err)
+	assert.Equal(t, wsText, opcode)
+	assert.Equal(t, "/updates passed hello", string(message))
+}
+
+func TestProxy_ServerSentEvents_NotBuffered(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: hello\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	u, _ := url.Parse(backend.URL)
+	server := newTestServer(configWithRoutes(domain.Route{
+		Type:                      domain.RouteTypeProxy,
+		PathPattern:               &domain.PathPattern{Regexp: regexp.MustCompile("^/events")},
+		Backend:                   &domain.Backend{URL: u},
+		ProxyResponseReplacements: map[string]string{"hello": "bye"},
+	}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/events")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	line := make(chan string)
+	go func() {
+		l, _ := bufio.NewReader(res.Body).ReadString('\n')
+		line <- l
+	}()
+
+	select {
+	case l := <-line:
+		assert.Equal(t, "data: hello\n", l)
+	case <-time.After(2 * time.Second):
+		t.Fatal("event was buffered")
+	}
+}
+
+func TestProxy_WebSocket_Mock(t *testing.T) {
+	server := newTestServer(configWithRoutes(domain.Route{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Method: "GET", Path: "^/ws$"},
+			Response: domain.Response{
+				WebSocket: &domain.WebSocket{
+					Messages: []domain.WebSocketMessage{
+						{Body: `{"type": "welcome"}`},
+						{WaitFor: `"subscribe"`, Delay: &domain.Duration{Duration: 10 * time.Millisecond}, Body: `{"type": "update"}`},
+					},
+					Close: true,
+				},
+			},
+		},
+	}))
+	defer server.Close()
+
+	c := dialWebSocket(t, server.URL+"/ws")
+	defer c.conn.Close()
+
+	_, message, err := c.readMessage()
+	require.NoError(t, err)
+	assert.Equal(t, `{"type": "welcome"}`, string(message))
+
+	require.NoError(t, c.writeFrame(wsText, []byte(`{"type": "subscribe"}`)))
+	_, message, err = c.readMessage()
+	require.NoError(t, err)
+	assert.Equal(t, `{"type": "update"}`, string(message))
+
+	_, _, err = c.readMessage()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestProxy_WebSocket_MockWithoutUpgrade(t *testing.T) {
+	server := newTestServer(configWithRoutes(domain.Route{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Path: "^/ws$"},
+			Response:     domain.Response{WebSocket: &domain.WebSocket{}},
+		},
+	}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/ws")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusUpgradeRequired, res.StatusCode)
+}
+
+func TestProxy_WebSocket_MockBadHandshake(t *testing.T) {
+	server := newTestServer(configWithRoutes(domain.Route{
+		Type: domain.RouteTypeMock,
+		Mock: &domain.Mock{
+			MatchRequest: domain.MatchRequest{Path: "^/ws$"},
+			Response:     domain.Response{WebSocket: &domain.WebSocket{}},
+		},
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	// the connection isn't hijacked, so the client gets a normal error response
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Equal(t, "unsupported websocket version '8'", string(body))
+}
+
+// dialWebSocket opens a client websocket to an http:// URL
+func dialWebSocket(t *testing.T, rawURL string) *wsConn {
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", u.Host)
+	require.NoError(t, err)
+
+	var nonce [16]byte
+	_, _ = rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	require.NoError(t, req.Write(conn))
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	require.True(t, strings.EqualFold(res.Header.Get("Upgrade"), "websocket"))
+	require.Equal(t, wsAcceptKey(key), res.Header.Get("Sec-WebSocket-Accept"))
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &wsConn{conn: conn, br: br, mask: true}
+}